@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/navalesnahuel/slurp-tools/types"
+	"github.com/navalesnahuel/slurp-tools/util"
+)
+
+var errBatchPanicked = errors.New("processing failed unexpectedly")
+
+// handleBatchFilter applies one filter chain to many images at once. Without
+// atomic every image reports its own outcome, with it a single failure rolls
+// back the images that were already changed.
+func (sv *APIServer) handleBatchFilter(w http.ResponseWriter, r *http.Request) error {
+	var req types.BatchFilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return NewAPIError(err, 400)
+	}
+	if err := req.Validate(); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	chain := req.Chain()
+	if _, err := types.CreateFilters(chain); err != nil {
+		return NewAPIError(err, 400)
+	}
+	if req.Atomic {
+		for _, imageID := range req.Images {
+			if sv.imageStore.CanRedo(imageID) {
+				return NewAPIError(fmt.Errorf("batch: image %s has undone versions, an atomic batch would discard them for good", imageID), http.StatusConflict)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	versions := make([]*types.ImageVersion, len(req.Images))
+	errs := make([]error, len(req.Images))
+
+	// the limiter bounds the actual work, this only avoids parking a goroutine
+	// per image in its queue
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, imageID := range req.Images {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+
+			v, err := sv.filterStoredImageSafe(ctx, chain, imageID)
+			if err != nil {
+				errs[i] = err
+				if req.Atomic {
+					cancel()
+				}
+				return
+			}
+			versions[i] = &v
+		}()
+	}
+	wg.Wait()
+
+	result := types.BatchFilterResult{Results: make([]types.BatchItemResult, len(req.Images))}
+	var firstErr *APIError
+	for i, imageID := range req.Images {
+		item := types.BatchItemResult{UUID: imageID, Status: types.BatchOK}
+		switch {
+		case versions[i] != nil:
+			item.Version = &versions[i].Version
+			result.Succeeded++
+		case req.Atomic && errors.Is(errs[i], context.Canceled) && r.Context().Err() == nil:
+			// aborted because another image failed
+			item.Status = types.BatchSkipped
+		default:
+			apiErr := NewAPIError(errs[i], http.StatusUnprocessableEntity)
+			item.Status = types.BatchFailed
+			item.Error = apiErr.Err
+			item.Code = apiErr.Code
+			result.Failed++
+			if firstErr == nil {
+				firstErr = apiErr
+			}
+		}
+		result.Results[i] = item
+	}
+
+	if !req.Atomic || firstErr == nil {
+		return util.WriteJSON(w, http.StatusOK, result)
+	}
+
+	for i, v := range versions {
+		if v == nil {
+			continue
+		}
+		if err := sv.imageStore.DiscardVersion(v.UUID, v.Version); err != nil {
+			// edited by someone else meanwhile, keep their history intact
+			slog.Error("batch rollback failed", "request_id", RequestID(r.Context()), "uuid", v.UUID, "error", err)
+			continue
+		}
+		result.Results[i].Status = types.BatchRolledBack
+		result.Results[i].Version = nil
+		result.Succeeded--
+	}
+
+	resp := *firstErr
+	resp.Err = "batch aborted: " + firstErr.Err
+	resp.Details = result
+	return &resp
+}
+
+// filterStoredImageSafe is filterStoredImage with a panic turned into the
+// image's failure, net/http only recovers the handler's own goroutine
+func (sv *APIServer) filterStoredImageSafe(ctx context.Context, chain []types.FilterRequest, imageID string) (v types.ImageVersion, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			slog.Error("batch filter panicked", "request_id", RequestID(ctx), "uuid", imageID, "panic", p, "stack", string(debug.Stack()))
+			v, err = types.ImageVersion{}, errBatchPanicked
+		}
+	}()
+	return sv.filterStoredImage(ctx, chain, imageID)
+}
+
+func (sv *APIServer) filterStoredImage(ctx context.Context, chain []types.FilterRequest, imageID string) (types.ImageVersion, error) {
+	img, from, err := sv.imageStore.LoadCurrent(imageID)
+	if err != nil {
+		return types.ImageVersion{}, err
+	}
+	return sv.applyFiltersToImage(ctx, chain, img, from)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/navalesnahuel/slurp-tools/types"
+)
+
+var imageContentTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+}
+
+// serveDerived serves a representation of v that only changes with the
+// version, such as an encoded download or a thumbnail. It is rendered once and
+// cached on disk, and clients revalidate it with the ETag or Last-Modified
+// since the same URL points at a new version after every edit.
+func (sv *APIServer) serveDerived(w http.ResponseWriter, r *http.Request, v types.ImageVersion, variant, format string, render func() (image.Image, error)) error {
+	data, err := sv.imageStore.LoadDerived(v, variant)
+	if os.IsNotExist(err) {
+		data, err = sv.encodeDerived(r.Context(), format, render)
+		if err == nil {
+			if err := sv.imageStore.SaveDerived(v, variant, data); err != nil {
+				slog.Warn("derived image not cached", "request_id", RequestID(r.Context()), "uuid", v.UUID, "variant", variant, "error", err)
+			}
+		}
+	}
+	if err != nil {
+		return NewAPIError(err, 500)
+	}
+
+	w.Header().Set("Content-Type", imageContentTypes[format])
+	w.Header().Set("ETag", versionETag(v, variant))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", v.CreatedAt, bytes.NewReader(data))
+	return nil
+}
+
+// encodeDerived renders and encodes a derived image, render takes the
+// limiter itself for any work beyond decoding
+func (sv *APIServer) encodeDerived(ctx context.Context, format string, render func() (image.Image, error)) ([]byte, error) {
+	img, err := render()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := sv.acquire(ctx, img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, img, format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeImage writes img as png, jpeg or webp, webp output is lossless
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "webp":
+		return nativewebp.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// versionETag identifies a representation of one version the same way
+// derived files are named on disk
+func versionETag(v types.ImageVersion, variant string) string {
+	return fmt.Sprintf(`"%s-v%d-%x-%s"`, v.UUID, v.Version, v.CreatedAt.UnixNano(), variant)
+}
+
+// queryFormat reads the format query parameter, def when it is missing
+func queryFormat(r *http.Request, def string, allowed ...string) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return def, nil
+	}
+	for _, a := range allowed {
+		if format == a {
+			return format, nil
+		}
+	}
+	return "", NewAPIError(fmt.Errorf("format must be one of %v", allowed), 400)
+}
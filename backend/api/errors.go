@@ -1,23 +1,88 @@
 package api
 
 import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"math"
 	"net/http"
+	"time"
+
+	"github.com/navalesnahuel/slurp-tools/storage"
+	"github.com/navalesnahuel/slurp-tools/types"
 )
 
 type APIError struct {
-	Err    string `json:"error"`
-	Status int    `json:"-"`
+	Err        string `json:"error"`
+	Code       string `json:"code"`
+	RequestID  string `json:"request_id,omitempty"`
+	Details    any    `json:"details,omitempty"`
+	Status     int    `json:"-"`
+	RetryAfter int    `json:"-"` // seconds, sent as the Retry-After header when set
+}
+
+// Stable error codes clients can switch on, the message may change
+const (
+	CodeBadRequest          = "bad_request"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeConflict            = "conflict"
+	CodeUnprocessableEntity = "unprocessable_entity"
+	CodeInternal            = "internal_error"
+	CodeNotImplemented      = "not_implemented"
+	CodeBadGateway          = "bad_gateway"
+	CodeServiceUnavailable  = "service_unavailable"
+	CodeGatewayTimeout      = "gateway_timeout"
+
+	CodeInvalidFilter = "invalid_filter"
+	CodeImageNotFound = "image_not_found"
+	CodeStorage       = "storage_error"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusMethodNotAllowed:    CodeMethodNotAllowed,
+	http.StatusConflict:            CodeConflict,
+	http.StatusUnprocessableEntity: CodeUnprocessableEntity,
+	http.StatusInternalServerError: CodeInternal,
+	http.StatusNotImplemented:      CodeNotImplemented,
+	http.StatusBadGateway:          CodeBadGateway,
+	http.StatusServiceUnavailable:  CodeServiceUnavailable,
+	http.StatusGatewayTimeout:      CodeGatewayTimeout,
 }
 
 func (e *APIError) Error() string {
 	return e.Err
 }
 
+// NewAPIError wraps err with status. Known errors get their own code and
+// status, and errors from the file system are replaced by a generic message
+// so paths never reach the client.
 func NewAPIError(err any, status int) *APIError {
 	var errStr string
 
 	switch e := err.(type) {
+	case *APIError:
+		return e
 	case error:
+		var filterErr *types.FilterError
+		var pathErr *fs.PathError
+		switch {
+		case errors.As(e, &filterErr):
+			return &APIError{Err: filterErr.Error(), Code: CodeInvalidFilter, Details: filterErr, Status: http.StatusBadRequest}
+		case errors.Is(e, storage.ErrNotFound):
+			return &APIError{Err: e.Error(), Code: CodeImageNotFound, Status: http.StatusNotFound}
+		case errors.Is(e, storage.ErrStale):
+			return &APIError{Err: e.Error(), Code: CodeConflict, Status: http.StatusConflict}
+		case errors.As(e, &pathErr):
+			slog.Error("storage error", "error", e)
+			return &APIError{Err: "the image could not be read or written.", Code: CodeStorage, Status: http.StatusInternalServerError}
+		}
 		errStr = e.Error()
 	case string:
 		errStr = e
@@ -25,8 +90,17 @@ func NewAPIError(err any, status int) *APIError {
 		errStr = "unkown error"
 	}
 
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeBadRequest
+		if status >= 500 {
+			code = CodeInternal
+		}
+	}
+
 	return &APIError{
 		Err:    errStr,
+		Code:   code,
 		Status: status,
 	}
 }
@@ -46,3 +120,13 @@ var (
 	ErrorServiceUnavailable = NewAPIError("the service is temporarily unavailable. Please try again later.", http.StatusServiceUnavailable)
 	ErrorGatewayTimeout     = NewAPIError("the server did not receive a timely response from an upstream server.", http.StatusGatewayTimeout)
 )
+
+// NewRetryableError is a 503 telling the client when it is worth trying again
+func NewRetryableError(retryAfter time.Duration) *APIError {
+	return &APIError{
+		Err:        ErrorServiceUnavailable.Err,
+		Code:       CodeServiceUnavailable,
+		Status:     http.StatusServiceUnavailable,
+		RetryAfter: int(math.Ceil(retryAfter.Seconds())),
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/navalesnahuel/slurp-tools/export"
+	"github.com/navalesnahuel/slurp-tools/types"
+)
+
+var exportContentTypes = map[string]string{
+	types.ExportPDF:  "application/pdf",
+	types.ExportTIFF: "image/tiff",
+	types.ExportZIP:  "application/zip",
+}
+
+var exportExtensions = map[string]string{
+	types.ExportPDF:  ".pdf",
+	types.ExportTIFF: ".tif",
+	types.ExportZIP:  ".zip",
+}
+
+type exportItem struct {
+	entry types.ExportEntry
+	image image.Image
+}
+
+func (sv *APIServer) handleExport(w http.ResponseWriter, r *http.Request) error {
+	var req types.ExportRequest
+	var items []exportItem
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		req, items, err = parseExportUploads(r)
+	} else {
+		req, items, err = sv.parseExportRefs(r)
+	}
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch req.Container {
+	case types.ExportPDF:
+		pages := make([]pdfPage, 0, len(items))
+		for _, item := range items {
+			pages = append(pages, pdfPage{Name: item.entry.Name, Image: item.image})
+		}
+
+		data, err := buildPDF(pages, req.PDFOptions)
+		if errors.Is(err, errNoPDFPages) {
+			return NewAPIError(err, 422)
+		}
+		if err != nil {
+			return NewAPIError(err, 500)
+		}
+		buf.Write(data)
+	case types.ExportTIFF:
+		pages := make([]export.TIFFPage, 0, len(items))
+		for _, item := range items {
+			if req.SkipBlank && types.AnalyzePage(item.image).Blank {
+				continue
+			}
+			pages = append(pages, export.TIFFPage{Image: item.image, Format: item.entry.Format})
+		}
+
+		if len(pages) == 0 {
+			return NewAPIError("no pages to include in the TIFF", 422)
+		}
+		if err := export.WriteTIFF(&buf, pages, req.DPI); err != nil {
+			return NewAPIError(err, 500)
+		}
+	case types.ExportZIP:
+		entries := make([]export.ZIPEntry, 0, len(items))
+		for _, item := range items {
+			quality := item.entry.Quality
+			if quality == 0 {
+				quality = req.JPEGQuality
+			}
+			entries = append(entries, export.ZIPEntry{
+				Name:    item.entry.Name,
+				Image:   item.image,
+				Format:  item.entry.Format,
+				Quality: quality,
+				DPI:     req.DPI,
+			})
+		}
+
+		if err := export.WriteZIP(&buf, entries); err != nil {
+			return NewAPIError(err, 500)
+		}
+	}
+
+	filename := strings.TrimSuffix(req.DownloadName(), ".pdf") + exportExtensions[req.Container]
+	return writeAttachment(w, buf.Bytes(), exportContentTypes[req.Container], filename)
+}
+
+func (sv *APIServer) parseExportRefs(r *http.Request) (types.ExportRequest, []exportItem, error) {
+	req := types.ExportRequest{PDFOptions: types.DefaultPDFOptions()}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, nil, NewAPIError(err, 400)
+	}
+	req.Container = strings.ToLower(req.Container)
+
+	if err := req.Validate(); err != nil {
+		return req, nil, NewAPIError(err, 400)
+	}
+
+	items := make([]exportItem, 0, len(req.Images))
+	for _, entry := range req.Images {
+		img, err := sv.loadImageRef(entry.ImageRef)
+		if err != nil {
+			return req, nil, NewAPIError(err, 404)
+		}
+
+		if entry.Name == "" {
+			entry.Name = entry.UUID
+		}
+		items = append(items, exportItem{entry: entry, image: img})
+	}
+
+	return req, items, nil
+}
+
+// parseExportUploads reads the images from a multipart form. format and quality
+// may be sent once for every image or once per image, in upload order.
+func parseExportUploads(r *http.Request) (types.ExportRequest, []exportItem, error) {
+	var req types.ExportRequest
+	if err := r.ParseMultipartForm(55 << 20); err != nil {
+		return req, nil, NewAPIError(err, 400)
+	}
+
+	opts, err := parsePDFOptions(r)
+	if err != nil {
+		return req, nil, NewAPIError(err, 400)
+	}
+	req.PDFOptions = opts
+	req.Container = strings.ToLower(r.FormValue("container"))
+
+	files := r.MultipartForm.File["image"]
+	formats := r.Form["format"]
+	qualities := r.Form["quality"]
+
+	items := make([]exportItem, 0, len(files))
+	for i, fileHeader := range files {
+		entry := types.ExportEntry{Name: fileHeader.Filename}
+		if v := formValueAt(formats, i); v != "" {
+			entry.Format = strings.ToLower(v)
+		}
+		if v := formValueAt(qualities, i); v != "" {
+			q, err := strconv.Atoi(v)
+			if err != nil {
+				return req, nil, NewAPIError("quality must be an integer", 400)
+			}
+			entry.Quality = q
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			return req, nil, NewAPIError(err, 400)
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			return req, nil, NewAPIError(fmt.Sprintf("failed to decode image %s", fileHeader.Filename), 400)
+		}
+
+		req.Images = append(req.Images, entry)
+		items = append(items, exportItem{entry: entry, image: img})
+	}
+
+	if err := req.Validate(); err != nil {
+		return req, nil, NewAPIError(err, 400)
+	}
+
+	return req, items, nil
+}
+
+func formValueAt(values []string, i int) string {
+	switch {
+	case len(values) == 1:
+		return values[0]
+	case i < len(values):
+		return values[i]
+	}
+	return ""
+}
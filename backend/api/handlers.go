@@ -1,18 +1,14 @@
 package api
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
-	"image/png"
-	"math"
 	"net/http"
-	"time"
 
-	"codeberg.org/go-pdf/fpdf"
 	"github.com/gorilla/mux"
 	"github.com/navalesnahuel/slurp-tools/types"
 	"github.com/navalesnahuel/slurp-tools/util"
@@ -24,7 +20,7 @@ func (sv *APIServer) handleUploadImage(w http.ResponseWriter, r *http.Request) e
 		return NewAPIError(err, 400)
 	}
 
-	imageProps, err := sv.processImageUpload(file, header)
+	imageProps, err := sv.processImageUpload(r.Context(), file, header)
 	if err != nil {
 		return NewAPIError(err, 400)
 	}
@@ -40,7 +36,7 @@ func (sv *APIServer) handleApplyFilterToImage(w http.ResponseWriter, r *http.Req
 		return NewAPIError("provide a valid image id", 400)
 	}
 
-	img, err := sv.imageStore.LoadLatest(imageID)
+	img, from, err := sv.imageStore.LoadCurrent(imageID)
 	if err != nil {
 		return NewAPIError(err, 400)
 	}
@@ -51,7 +47,10 @@ func (sv *APIServer) handleApplyFilterToImage(w http.ResponseWriter, r *http.Req
 		return NewAPIError(err, 400)
 	}
 
-	imgProps, err := sv.applyFiltersToImage(filterRequests, img, imageID)
+	imgProps, err := sv.applyFiltersToImage(r.Context(), filterRequests, img, from)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
 
 	return util.WriteJSON(w, 201, imgProps)
 }
@@ -88,6 +87,8 @@ func (sv *APIServer) handleRedo(w http.ResponseWriter, r *http.Request) error {
 	return util.WriteJSON(w, 200, img)
 }
 
+// handleServeFile serves the current version as png, or in the format asked
+// for, encoded once per version
 func (sv *APIServer) handleServeFile(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	imageID, ok := vars["image_id"]
@@ -95,21 +96,34 @@ func (sv *APIServer) handleServeFile(w http.ResponseWriter, r *http.Request) err
 		return NewAPIError("provide a valid image id", 400)
 	}
 
-	img, err := sv.imageStore.LoadLatest(imageID)
+	format, err := queryFormat(r, "png", "png", "jpeg", "webp")
+	if err != nil {
+		return err
+	}
+
+	v, err := sv.imageStore.Current(imageID)
 	if err != nil {
 		return NewAPIError(err, 400)
 	}
 
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
-		return NewAPIError("failed to encode image", 500)
+	return sv.serveDerived(w, r, v, "download."+format, format, func() (image.Image, error) {
+		return sv.imageStore.LoadImage(v.FilePath)
+	})
+}
+
+func (sv *APIServer) handleImageAnalysis(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	imageID, ok := vars["image_id"]
+	if !ok {
+		return NewAPIError("provide a valid image id", 400)
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Content-Length", fmt.Sprint(buf.Len()))
+	img, err := sv.imageStore.LoadLatest(imageID)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
 
-	http.ServeContent(w, r, imageID+".png", time.Now(), bytes.NewReader(buf.Bytes()))
-	return nil
+	return util.WriteJSON(w, 200, types.AnalyzePage(img))
 }
 
 func (sv *APIServer) handleScanner(w http.ResponseWriter, r *http.Request) error {
@@ -130,31 +144,20 @@ func (sv *APIServer) handleScanner(w http.ResponseWriter, r *http.Request) error
 		return NewAPIError(err, 400)
 	}
 
-	imageProperties, err := sv.processImageUpload(file, header)
+	imageProperties, err := sv.processImageUpload(r.Context(), file, header)
 	if err != nil {
 		return NewAPIError(err, 400)
 	}
 
-	img, err := sv.imageStore.LoadLatest(imageProperties.UUID)
+	img, from, err := sv.imageStore.LoadCurrent(imageProperties.UUID)
 	if err != nil {
 		return NewAPIError(err, 400)
 	}
 
-	resp, err := sv.sendImageToScanner(img, imageProperties.UUID, points)
+	imageFilters, err := sv.scanImage(r.Context(), img, from, points)
 	if err != nil {
 		return NewAPIError(err, 500)
 	}
-	defer resp.Body.Close()
-
-	scannedImage, _, err := image.Decode(resp.Body)
-	if err != nil {
-		return NewAPIError(fmt.Errorf("could not decode image from scanner: %w", err), 500)
-	}
-
-	imageFilters, err := sv.applyFiltersToImage(ScanFilterPayload, scannedImage, imageProperties.UUID)
-	if err != nil {
-		return NewAPIError(err, 400)
-	}
 
 	return util.WriteJSON(w, 200, imageFilters)
 }
@@ -167,10 +170,12 @@ func (sv *APIServer) handlerImageToPDF(w http.ResponseWriter, r *http.Request) e
 	form := r.MultipartForm
 	files := form.File["image"]
 
-	pdf := fpdf.New("P", "mm", "A4", "")
-	const maxWidth = 190.0
-	const maxHeight = 277.0
+	opts, err := parsePDFOptions(r)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
 
+	pages := make([]pdfPage, 0, len(files))
 	for _, fileHeader := range files {
 		file, err := fileHeader.Open()
 		if err != nil {
@@ -183,42 +188,43 @@ func (sv *APIServer) handlerImageToPDF(w http.ResponseWriter, r *http.Request) e
 			return NewAPIError("failed to decode image", 400)
 		}
 
-		width := float64(img.Bounds().Dx())
-		height := float64(img.Bounds().Dy())
+		pages = append(pages, pdfPage{Name: fileHeader.Filename, Image: img})
+	}
 
-		scale := math.Min(maxWidth/width, maxHeight/height)
-		displayWidth := width * scale
-		displayHeight := height * scale
+	pdfBytes, err := buildPDF(pages, opts)
+	if errors.Is(err, errNoPDFPages) {
+		return NewAPIError(err, 422)
+	}
+	if err != nil {
+		return NewAPIError(err, 500)
+	}
 
-		var buf bytes.Buffer
-		if err := png.Encode(&buf, img); err != nil {
-			return NewAPIError("failed to encode image", 500)
-		}
+	return writePDF(w, pdfBytes, opts.DownloadName())
+}
 
-		imgName := fileHeader.Filename
-		options := fpdf.ImageOptions{ReadDpi: false, ImageType: "PNG"}
-		pdf.AddPage()
-		pdf.RegisterImageOptionsReader(imgName, options, &buf)
+func (sv *APIServer) handleStoredImagesToPDF(w http.ResponseWriter, r *http.Request) error {
+	req, err := parsePDFRequest(r)
+	if err != nil {
+		return err
+	}
 
-		x := (210 - displayWidth) / 2  // Center horizontally
-		y := (297 - displayHeight) / 2 // Center vertically
+	pages := make([]pdfPage, 0, len(req.Images))
+	for _, ref := range req.Images {
+		img, err := sv.loadImageRef(ref)
+		if err != nil {
+			return NewAPIError(err, 404)
+		}
 
-		pdf.ImageOptions(imgName, x, y, displayWidth, displayHeight, false, options, 0, "")
+		pages = append(pages, pdfPage{Name: ref.UUID, Image: img})
+	}
 
+	pdfBytes, err := buildPDF(pages, req.PDFOptions)
+	if errors.Is(err, errNoPDFPages) {
+		return NewAPIError(err, 422)
 	}
-	var pdfBuf bytes.Buffer
-	err = pdf.Output(&pdfBuf)
 	if err != nil {
-		return NewAPIError("failed to generate PDF", 500)
+		return NewAPIError(err, 500)
 	}
 
-	w.Header().Set("Content-Type", "application/pdf")
-	w.Header().Set("Content-Disposition", "attachment; filename=slurptools.pdf")
-	w.WriteHeader(http.StatusOK)
-
-	_, err = w.Write(pdfBuf.Bytes())
-	if err != nil {
-		return NewAPIError("failed to send PDF", 500)
-	}
-	return nil
+	return writePDF(w, pdfBytes, req.DownloadName())
 }
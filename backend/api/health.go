@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/navalesnahuel/slurp-tools/util"
+)
+
+// handleHealth only tells the process is up and serving requests
+func (sv *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	return util.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReady reports whether every dependency is usable, with 503 and the
+// failing checks otherwise
+func (sv *APIServer) handleReady(w http.ResponseWriter, r *http.Request) error {
+	report := sv.health.Run(r.Context())
+
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	return util.WriteJSON(w, status, report)
+}
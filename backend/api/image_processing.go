@@ -1,45 +1,56 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
 	"image"
-	"image/jpeg"
+	"log/slog"
 	"mime/multipart"
-	"net/http"
+	"time"
 
 	"github.com/disintegration/gift"
 	"github.com/google/uuid"
+	"github.com/navalesnahuel/slurp-tools/limiter"
+	"github.com/navalesnahuel/slurp-tools/metrics"
+	"github.com/navalesnahuel/slurp-tools/scanner"
+	"github.com/navalesnahuel/slurp-tools/tracing"
 	"github.com/navalesnahuel/slurp-tools/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// how long clients are told to wait when the processing limiter is saturated
+const retryAfter = 5 * time.Second
+
 type ScannerRequest struct {
 	Image  image.Image `json:"image"`
 	Points [][]int     `json:"points"`
 }
 
-var ScanFilterPayload = []types.FilterRequest{
-	{Filter: "grayscale"},
-	{Filter: "scanify"},
-	{Filter: "median", Params: json.RawMessage(`{"radius": 1, "alpha": false}`)},
-	{Filter: "brightness", Params: json.RawMessage(`{"percentage": 15.0}`)},
-	{Filter: "contrast", Params: json.RawMessage(`{"percentage": 60.0}`)},
-	{Filter: "unsharpmask", Params: json.RawMessage(`{"sigma": 1.5, "amount": 1.0, "threshold": 0.5}`)},
-}
+var ScanFilterPayload = types.FilterPresets["scan"]
 
-func (sv *APIServer) processImageUpload(file multipart.File, header *multipart.FileHeader) (types.ImageVersion, error) {
+func (sv *APIServer) processImageUpload(ctx context.Context, file multipart.File, header *multipart.FileHeader) (_ types.ImageVersion, err error) {
 	defer file.Close()
-	err := types.ValidateImage(header.Filename)
+	ctx, span := tracing.Tracer.Start(ctx, "processImageUpload", trace.WithAttributes(
+		attribute.String("file.name", header.Filename),
+		attribute.Int64("file.size", header.Size),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	err = types.ValidateImage(header.Filename)
 	if err != nil {
 		return types.ImageVersion{}, err
 	}
-	imgDecoded, _, err := image.Decode(file)
+
+	_, decodeSpan := tracing.Tracer.Start(ctx, "image.Decode")
+	imgDecoded, format, err := image.Decode(file)
+	decodeSpan.SetAttributes(attribute.String("image.format", format))
+	tracing.End(decodeSpan, err)
 	if err != nil {
 		return types.ImageVersion{}, err
 	}
 	fileID := uuid.NewString()
-	imageProps, err := sv.imageStore.SaveVersion(fileID, imgDecoded)
+	imageProps, err := sv.imageStore.SaveVersion(ctx, fileID, 0, imgDecoded)
 	if err != nil {
 		return types.ImageVersion{}, err
 	}
@@ -47,70 +58,133 @@ func (sv *APIServer) processImageUpload(file multipart.File, header *multipart.F
 	return imageProps, nil
 }
 
-func (sv *APIServer) applyFiltersToImage(filters []types.FilterRequest, image image.Image, imageID string) (types.ImageVersion, error) {
-	giftFilters := make([]gift.Filter, 0, len(filters))
+// applyFiltersToImage runs the filters on src, the raster of version from,
+// and stores the result as a new version whose recipe is the one of from
+// with the filters added as steps. Nothing is stored when ctx is canceled
+// midway.
+func (sv *APIServer) applyFiltersToImage(ctx context.Context, filters []types.FilterRequest, src image.Image, from types.ImageVersion) (types.ImageVersion, error) {
+	imageID := from.UUID
+	recipe, err := sv.imageStore.Recipe(from)
+	if err != nil {
+		return types.ImageVersion{}, err
+	}
 	for _, fr := range filters {
-		filter, err := types.CreateFilter(fr)
-		if err != nil {
-			return types.ImageVersion{}, err
-		}
-		giftFilters = append(giftFilters, filter.ToGift())
+		recipe.Steps = append(recipe.Steps, types.RecipeStep{ID: uuid.NewString(), FilterRequest: fr})
 	}
 
-	imgWithFilters := types.ApplyFilters(image, giftFilters...)
+	// keep the intermediate results around for later recipe edits
+	keys := recipeKeys(imageID, recipe)
+	offset := len(recipe.Steps) - len(filters)
+	if _, ok := sv.renders.Get(keys[offset]); !ok {
+		sv.renders.Add(keys[offset], src, rasterSize(src))
+	}
 
-	imgProperties, err := sv.imageStore.SaveVersion(imageID, imgWithFilters)
+	imgWithFilters, err := sv.runFilters(ctx, filters, src, func(i int, img image.Image) {
+		sv.renders.Add(keys[offset+i+1], img, rasterSize(img))
+	})
 	if err != nil {
 		return types.ImageVersion{}, err
 	}
 
-	return imgProperties, nil
+	return sv.imageStore.SaveRecipeVersion(ctx, imageID, from.Generation, imgWithFilters, recipe)
 }
 
-func (sv *APIServer) sendImageToScanner(image image.Image, uuid string, points [][]int) (*http.Response, error) {
-	var buf bytes.Buffer
-	err := jpeg.Encode(&buf, image, nil)
+// runFilters applies the filters one at a time within the limiter's budget,
+// step, when set, gets every intermediate result
+func (sv *APIServer) runFilters(ctx context.Context, filters []types.FilterRequest, src image.Image, step func(i int, img image.Image)) (image.Image, error) {
+	created, err := types.CreateFilters(filters)
 	if err != nil {
-		return nil, fmt.Errorf("could not encode image: %w", err)
+		return nil, err
+	}
+	giftFilters := make([]gift.Filter, 0, len(created))
+	for _, filter := range created {
+		giftFilters = append(giftFilters, filter.ToGift())
 	}
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	imagePart, err := writer.CreateFormFile("file", "image.jpg")
+	release, err := sv.acquire(ctx, src.Bounds(), giftFilters...)
 	if err != nil {
-		return nil, fmt.Errorf("could not create image form field: %w", err)
+		return nil, err
 	}
-	_, err = imagePart.Write(buf.Bytes())
-	if err != nil {
-		return nil, fmt.Errorf("could not write image to form: %w", err)
+	defer release()
+
+	imgWithFilters := src
+	for i, f := range giftFilters {
+		start := time.Now()
+		_, span := tracing.Tracer.Start(ctx, "filter "+filters[i].Filter, trace.WithAttributes(
+			attribute.String("filter.name", filters[i].Filter),
+			attribute.Int("filter.index", i),
+		))
+		imgWithFilters, err = types.ApplyFiltersContext(ctx, imgWithFilters, f)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		metrics.FilterDuration.WithLabelValues(filters[i].Filter).Observe(time.Since(start).Seconds())
+
+		if step != nil {
+			step(i, imgWithFilters)
+		}
 	}
 
-	pointsJSON, err := json.Marshal(points)
+	return imgWithFilters, nil
+}
+
+// acquire waits for the limiter's budget to run filters on an image of the
+// given bounds
+func (sv *APIServer) acquire(ctx context.Context, bounds image.Rectangle, filters ...gift.Filter) (func(), error) {
+	_, waitSpan := tracing.Tracer.Start(ctx, "limiter.Acquire")
+	release, err := sv.limiter.Acquire(ctx, types.EstimateFilterMemory(bounds, filters...))
+	tracing.End(waitSpan, err)
 	if err != nil {
-		return nil, fmt.Errorf("could not marshal points: %w", err)
+		return nil, limiterError(err)
 	}
-	err = writer.WriteField("points", string(pointsJSON))
-	if err != nil {
-		return nil, fmt.Errorf("could not write points to form: %w", err)
+	return release, nil
+}
+
+func (sv *APIServer) loadImageRef(ref types.ImageRef) (image.Image, error) {
+	if ref.Version != nil {
+		return sv.imageStore.LoadVersion(ref.UUID, *ref.Version)
 	}
+	return sv.imageStore.LoadLatest(ref.UUID)
+}
 
-	err = writer.Close()
+// scanImage straightens img, the raster of version from, through the scanner
+// service and stores the cleaned up result as a new version
+func (sv *APIServer) scanImage(ctx context.Context, img image.Image, from types.ImageVersion, points [][]int) (types.ImageVersion, error) {
+	scannedImage, err := sv.scanner.Scan(ctx, img, points)
 	if err != nil {
-		return nil, fmt.Errorf("could not close multipart writer: %w", err)
+		return types.ImageVersion{}, scannerError(err)
 	}
 
-	req, err := http.NewRequest("POST", "http://localhost:8000/scanner", body)
+	// the scanner output can not be re-rendered, the result becomes a new base
+	cleaned, err := sv.runFilters(ctx, ScanFilterPayload, scannedImage, nil)
 	if err != nil {
-		return nil, fmt.Errorf("could not create request: %w", err)
+		return types.ImageVersion{}, err
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return sv.imageStore.SaveVersion(ctx, from.UUID, from.Generation, cleaned)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("could not send request: %w", err)
+func limiterError(err error) error {
+	if errors.Is(err, limiter.ErrBusy) {
+		return NewRetryableError(retryAfter)
+	}
+	return err
+}
+
+// scannerError maps scanner client failures to gateway errors, the details are
+// logged since they mean nothing to the client
+func scannerError(err error) error {
+	var circuitErr *scanner.CircuitOpenError
+	switch {
+	case errors.As(err, &circuitErr):
+		return NewRetryableError(circuitErr.RetryAfter)
+	case errors.Is(err, context.Canceled):
+		return err
+	case errors.Is(err, scanner.ErrTimeout):
+		slog.Error("scanner request timed out")
+		return ErrorGatewayTimeout
 	}
 
-	return resp, nil
+	slog.Error("scanner request failed", "error", err)
+	return ErrorBadGateway
 }
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/navalesnahuel/slurp-tools/jobs"
+	"github.com/navalesnahuel/slurp-tools/types"
+	"github.com/navalesnahuel/slurp-tools/util"
+)
+
+// Asynchronous versions of the slow endpoints. Requests are validated up front,
+// the work runs on the job queue and the client polls /jobs/{job_id}.
+
+func (sv *APIServer) handleFilterJob(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	imageID, ok := vars["image_id"]
+	if !ok {
+		return NewAPIError("provide a valid image id", 400)
+	}
+
+	img, from, err := sv.imageStore.LoadCurrent(imageID)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	var filterRequests []types.FilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&filterRequests); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	if _, err := types.CreateFilters(filterRequests); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	return sv.submitJob(w, "filter", func(ctx context.Context, job *jobs.Job) (*jobs.Result, error) {
+		imgProps, err := sv.applyFiltersToImage(ctx, filterRequests, img, from)
+		if err != nil {
+			return nil, err
+		}
+		return jsonResult(imgProps)
+	})
+}
+
+func (sv *APIServer) handleScanJob(w http.ResponseWriter, r *http.Request) error {
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+	defer file.Close()
+
+	var points [][]int
+	pointsStr := r.FormValue("points")
+	if pointsStr == "" {
+		return NewAPIError(fmt.Errorf("points are required"), 400)
+	}
+	if err := json.Unmarshal([]byte(pointsStr), &points); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	imageProperties, err := sv.processImageUpload(r.Context(), file, header)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	img, from, err := sv.imageStore.LoadCurrent(imageProperties.UUID)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	return sv.submitJob(w, "scan", func(ctx context.Context, job *jobs.Job) (*jobs.Result, error) {
+		imgProps, err := sv.scanImage(ctx, img, from, points)
+		if err != nil {
+			return nil, err
+		}
+		return jsonResult(imgProps)
+	})
+}
+
+func (sv *APIServer) handlePDFJob(w http.ResponseWriter, r *http.Request) error {
+	req, err := parsePDFRequest(r)
+	if err != nil {
+		return err
+	}
+
+	return sv.submitJob(w, "pdf", func(ctx context.Context, job *jobs.Job) (*jobs.Result, error) {
+		pages := make([]pdfPage, 0, len(req.Images))
+		for i, ref := range req.Images {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			img, err := sv.loadImageRef(ref)
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, pdfPage{Name: ref.UUID, Image: img})
+
+			// loading is about half of the work, encoding the pages the rest
+			job.SetProgress(0.5 * float64(i+1) / float64(len(req.Images)))
+		}
+
+		pdfBytes, err := buildPDF(pages, req.PDFOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		return &jobs.Result{ContentType: "application/pdf", Filename: req.DownloadName(), Data: pdfBytes}, nil
+	})
+}
+
+func (sv *APIServer) handleJobStatus(w http.ResponseWriter, r *http.Request) error {
+	info, err := sv.jobs.Get(mux.Vars(r)["job_id"])
+	if err != nil {
+		return jobError(err)
+	}
+
+	return util.WriteJSON(w, 200, info)
+}
+
+func (sv *APIServer) handleCancelJob(w http.ResponseWriter, r *http.Request) error {
+	info, err := sv.jobs.Cancel(mux.Vars(r)["job_id"])
+	if err != nil {
+		return jobError(err)
+	}
+
+	return util.WriteJSON(w, 200, info)
+}
+
+func (sv *APIServer) handleJobResult(w http.ResponseWriter, r *http.Request) error {
+	result, err := sv.jobs.Result(mux.Vars(r)["job_id"])
+	if err != nil {
+		return jobError(err)
+	}
+
+	if result.Filename != "" {
+		return writeAttachment(w, result.Data, result.ContentType, result.Filename)
+	}
+
+	w.Header().Set("Content-Type", result.ContentType)
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(result.Data)
+	return err
+}
+
+func (sv *APIServer) submitJob(w http.ResponseWriter, kind string, fn jobs.Func) error {
+	info, err := sv.jobs.Submit(kind, fn)
+	if err != nil {
+		return jobError(err)
+	}
+
+	w.Header().Set("Location", "/jobs/"+info.ID)
+	return util.WriteJSON(w, http.StatusAccepted, info)
+}
+
+func jsonResult(v any) (*jobs.Result, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &jobs.Result{ContentType: "application/json", Data: data}, nil
+}
+
+func jobError(err error) error {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		return NewAPIError(err, 404)
+	case errors.Is(err, jobs.ErrNotFinished), errors.Is(err, jobs.ErrFinished):
+		return NewAPIError(err, 409)
+	case errors.Is(err, jobs.ErrQueueFull):
+		return ErrorServiceUnavailable
+	}
+	return NewAPIError(err, 500)
+}
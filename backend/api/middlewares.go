@@ -1,11 +1,22 @@
 package api
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/navalesnahuel/slurp-tools/metrics"
+	"github.com/navalesnahuel/slurp-tools/tracing"
 	"github.com/navalesnahuel/slurp-tools/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type APIFunc func(http.ResponseWriter, *http.Request) error
@@ -16,11 +27,19 @@ func MakeHTTPHandler(f APIFunc) http.HandlerFunc {
 		err := f(w, r)
 		if err != nil {
 			if apiError, ok := err.(*APIError); ok {
-				util.WriteJSON(w, apiError.Status, apiError)
+				if apiError.RetryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(apiError.RetryAfter))
+				}
+				// copy, predefined errors are shared between requests
+				resp := *apiError
+				resp.RequestID = RequestID(r.Context())
+				util.WriteJSON(w, resp.Status, resp)
 				return
 			}
 
-			util.WriteJSON(w, http.StatusInternalServerError, APIError{Err: "internal server error"})
+			// unexpected errors are logged, their text may hold internals
+			slog.ErrorContext(r.Context(), "unhandled error", "request_id", RequestID(r.Context()), "error", err)
+			util.WriteJSON(w, http.StatusInternalServerError, APIError{Err: "internal server error", Code: CodeInternal, RequestID: RequestID(r.Context())})
 			return
 		}
 	}
@@ -29,23 +48,140 @@ func MakeHTTPHandler(f APIFunc) http.HandlerFunc {
 // logger handler
 type LoggingResponseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	wroteHeader bool
+	bytes       int
 }
 
 func (lw *LoggingResponseWriter) WriteHeader(code int) {
-	lw.statusCode = code
+	if !lw.wroteHeader {
+		lw.statusCode = code
+		lw.wroteHeader = true
+	}
 	lw.ResponseWriter.WriteHeader(code)
 }
 
+func (lw *LoggingResponseWriter) Write(b []byte) (int, error) {
+	if !lw.wroteHeader {
+		// net/http sends a 200 when the body is written first
+		lw.WriteHeader(http.StatusOK)
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestID returns the id MakeLoggerHandler assigned to the request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID keeps the caller's X-Request-ID when it looks sane, otherwise a new
+// one is generated
+func requestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		return uuid.NewString()
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return uuid.NewString()
+		}
+	}
+	return id
+}
+
+// routeTemplate is the mux path template of the matched route, ids in the URL
+// would make every request unique
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unknown"
+}
+
 func MakeLoggerHandler(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		lw := &LoggingResponseWriter{ResponseWriter: w, statusCode: 500}
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		lw := &LoggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(lw, r)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("route", routeTemplate(r)),
+			slog.Int("status", lw.statusCode),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int64("request_bytes", r.ContentLength),
+			slog.Int("response_bytes", lw.bytes),
+		}
+		if imageID := mux.Vars(r)["image_id"]; imageID != "" {
+			attrs = append(attrs, slog.String("image_id", imageID))
+		}
+
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case lw.statusCode >= 500:
+			level = slog.LevelError
+		case lw.statusCode >= 400:
+			level = slog.LevelWarn
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	}
+}
+
+// metrics handler, requests are labeled by route template so ids do not
+// create a series each
+func MakeMetricsHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &LoggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		next.ServeHTTP(lw, r)
-		slog.Info(fmt.Sprintf("- %d - %s -%s", lw.statusCode, r.Method, r.URL.String()))
+
+		route := routeTemplate(r)
+		status := strconv.Itoa(lw.statusCode)
+		metrics.RequestsTotal.WithLabelValues(route, r.Method, status).Inc()
+		metrics.RequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// tracing handler, continues the caller's trace when it sent one
+func MakeTracingHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer.Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+		))
+		defer span.End()
+
+		lw := &LoggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", lw.statusCode))
+		if lw.statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(lw.statusCode))
+		}
 	}
 }
 
 // encapsulate handlers
 func Handlers(f APIFunc) http.HandlerFunc {
-	return MakeLoggerHandler(MakeHTTPHandler(f))
+	return MakeTracingHandler(MakeLoggerHandler(MakeMetricsHandler(MakeHTTPHandler(f))))
 }
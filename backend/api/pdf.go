@@ -0,0 +1,293 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"codeberg.org/go-pdf/fpdf"
+	"github.com/disintegration/gift"
+	"github.com/navalesnahuel/slurp-tools/types"
+)
+
+var errNoPDFPages = errors.New("no pages to include in the PDF")
+
+type pdfPage struct {
+	Name  string
+	Image image.Image
+}
+
+func parsePDFOptions(r *http.Request) (types.PDFOptions, error) {
+	opts := types.DefaultPDFOptions()
+
+	enumFields := map[string]*string{
+		"page_size":    &opts.PageSize,
+		"orientation":  &opts.Orientation,
+		"fit":          &opts.Fit,
+		"image_format": &opts.ImageFormat,
+	}
+	for field, dst := range enumFields {
+		if v := r.FormValue(field); v != "" {
+			*dst = strings.ToLower(v)
+		}
+	}
+
+	textFields := map[string]*string{
+		"title":          &opts.Title,
+		"author":         &opts.Author,
+		"subject":        &opts.Subject,
+		"keywords":       &opts.Keywords,
+		"filename":       &opts.Filename,
+		"user_password":  &opts.UserPassword,
+		"owner_password": &opts.OwnerPassword,
+	}
+	for field, dst := range textFields {
+		*dst = r.FormValue(field)
+	}
+	opts.Bookmarks = r.Form["bookmark"]
+
+	if v := r.FormValue("jpeg_quality"); v != "" {
+		q, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("jpeg_quality must be an integer")
+		}
+		opts.JPEGQuality = q
+	}
+
+	floatFields := map[string]*float64{
+		"page_width":  &opts.PageWidth,
+		"page_height": &opts.PageHeight,
+		"margin":      &opts.Margin,
+		"dpi":         &opts.DPI,
+		"target_dpi":  &opts.TargetDPI,
+	}
+	for field, dst := range floatFields {
+		v := r.FormValue(field)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be a number", field)
+		}
+		*dst = f
+	}
+
+	boolFields := map[string]*bool{
+		"skip_blank":   &opts.SkipBlank,
+		"page_numbers": &opts.PageNumbers,
+		"no_print":     &opts.NoPrint,
+		"no_copy":      &opts.NoCopy,
+		"no_modify":    &opts.NoModify,
+	}
+	for field, dst := range boolFields {
+		v := r.FormValue(field)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be a boolean", field)
+		}
+		*dst = b
+	}
+
+	return opts, opts.Validate()
+}
+
+func parsePDFRequest(r *http.Request) (types.PDFRequest, error) {
+	req := types.PDFRequest{PDFOptions: types.DefaultPDFOptions()}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, NewAPIError(err, 400)
+	}
+
+	if len(req.Images) == 0 {
+		return req, NewAPIError("provide at least one image", 400)
+	}
+
+	if err := req.PDFOptions.Validate(); err != nil {
+		return req, NewAPIError(err, 400)
+	}
+	return req, nil
+}
+
+func buildPDF(pages []pdfPage, opts types.PDFOptions) ([]byte, error) {
+	w, h := opts.PageDimensions(0, 0)
+	pdf := fpdf.NewCustom(&fpdf.InitType{
+		OrientationStr: "P",
+		UnitStr:        "mm",
+		Size:           fpdf.SizeType{Wd: w, Ht: h},
+	})
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetMargins(opts.Margin, opts.Margin, opts.Margin)
+	setPDFMetadata(pdf, opts)
+	setPDFProtection(pdf, opts)
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	for i, page := range pages {
+		if opts.SkipBlank && types.AnalyzePage(page.Image).Blank {
+			continue
+		}
+
+		if err := addImagePage(pdf, fmt.Sprintf("page%d-%s", i, page.Name), page.Image, opts); err != nil {
+			return nil, err
+		}
+
+		if i < len(opts.Bookmarks) && opts.Bookmarks[i] != "" {
+			pdf.Bookmark(tr(opts.Bookmarks[i]), 0, 0)
+		}
+	}
+
+	if pdf.PageNo() == 0 {
+		return nil, errNoPDFPages
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to generate PDF: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func setPDFMetadata(pdf *fpdf.Fpdf, opts types.PDFOptions) {
+	pdf.SetCreator("Slurp Tools", false)
+	if opts.Title != "" {
+		pdf.SetTitle(opts.Title, true)
+	}
+	if opts.Author != "" {
+		pdf.SetAuthor(opts.Author, true)
+	}
+	if opts.Subject != "" {
+		pdf.SetSubject(opts.Subject, true)
+	}
+	if opts.Keywords != "" {
+		pdf.SetKeywords(opts.Keywords, true)
+	}
+
+	if opts.PageNumbers {
+		pdf.AliasNbPages("")
+		pdf.SetFooterFunc(func() {
+			pdf.SetY(-opts.Margin/2 - 2.5)
+			pdf.SetFont("Helvetica", "", 9)
+			pdf.SetTextColor(80, 80, 80)
+			pdf.CellFormat(0, 5, fmt.Sprintf("%d / {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+		})
+	}
+}
+
+func setPDFProtection(pdf *fpdf.Fpdf, opts types.PDFOptions) {
+	if !opts.Encrypted() {
+		return
+	}
+
+	var allowed byte = fpdf.CnProtectPrint | fpdf.CnProtectCopy | fpdf.CnProtectModify | fpdf.CnProtectAnnotForms
+	if opts.NoPrint {
+		allowed &^= fpdf.CnProtectPrint
+	}
+	if opts.NoCopy {
+		allowed &^= fpdf.CnProtectCopy
+	}
+	if opts.NoModify {
+		allowed &^= fpdf.CnProtectModify | fpdf.CnProtectAnnotForms
+	}
+
+	pdf.SetProtection(allowed, opts.UserPassword, opts.OwnerPassword)
+}
+
+func addImagePage(pdf *fpdf.Fpdf, name string, img image.Image, opts types.PDFOptions) error {
+	width := float64(img.Bounds().Dx())
+	height := float64(img.Bounds().Dy())
+
+	pageWidth, pageHeight := opts.PageDimensions(img.Bounds().Dx(), img.Bounds().Dy())
+	maxWidth := pageWidth - 2*opts.Margin
+	maxHeight := pageHeight - 2*opts.Margin
+
+	var scale float64
+	switch opts.Fit {
+	case types.PDFFitFill:
+		scale = math.Max(maxWidth/width, maxHeight/height)
+	case types.PDFFitActual:
+		scale = 25.4 / opts.DPI
+	default:
+		scale = math.Min(maxWidth/width, maxHeight/height)
+	}
+	displayWidth := width * scale
+	displayHeight := height * scale
+
+	// Downsample so the placed image is not denser than the target DPI
+	if opts.TargetDPI > 0 {
+		maxPixels := int(math.Round(displayWidth / 25.4 * opts.TargetDPI))
+		if maxPixels > 0 && maxPixels < img.Bounds().Dx() {
+			img = types.ApplyFilters(img, gift.Resize(maxPixels, 0, gift.LanczosResampling))
+		}
+	}
+
+	buf, imageType, err := encodePDFImage(img, opts)
+	if err != nil {
+		return err
+	}
+
+	options := fpdf.ImageOptions{ReadDpi: false, ImageType: imageType}
+	pdf.AddPageFormat("P", fpdf.SizeType{Wd: pageWidth, Ht: pageHeight})
+	pdf.RegisterImageOptionsReader(name, options, buf)
+
+	// Centered inside the margins, anything overflowing them is clipped
+	x := (pageWidth - displayWidth) / 2
+	y := (pageHeight - displayHeight) / 2
+
+	pdf.ClipRect(opts.Margin, opts.Margin, maxWidth, maxHeight, false)
+	pdf.ImageOptions(name, x, y, displayWidth, displayHeight, false, options, 0, "")
+	pdf.ClipEnd()
+
+	return pdf.Error()
+}
+
+func encodePDFImage(img image.Image, opts types.PDFOptions) (*bytes.Buffer, string, error) {
+	var buf bytes.Buffer
+	var err error
+	imageType := "PNG"
+
+	switch opts.ImageFormat {
+	case types.PDFImageJPEG:
+		imageType = "JPG"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.JPEGQuality})
+	case types.PDFImageGray:
+		gray := image.NewGray(img.Bounds())
+		draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+		err = png.Encode(&buf, gray)
+	case types.PDFImageBilevel:
+		err = png.Encode(&buf, types.Binarize(img))
+	default:
+		err = png.Encode(&buf, img)
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	return &buf, imageType, nil
+}
+
+func writePDF(w http.ResponseWriter, data []byte, filename string) error {
+	return writeAttachment(w, data, "application/pdf", filename)
+}
+
+func writeAttachment(w http.ResponseWriter, data []byte, contentType, filename string) error {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(data); err != nil {
+		return NewAPIError("failed to send file", 500)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/navalesnahuel/slurp-tools/types"
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// PDF page operations, documents are edited structurally and never rasterized
+
+func (sv *APIServer) handleMergePDF(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseMultipartForm(55 << 20); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	files := r.MultipartForm.File["pdf"]
+	if len(files) < 2 {
+		return NewAPIError("provide at least two PDFs to merge", 400)
+	}
+
+	readers := make([]io.ReadSeeker, 0, len(files))
+	for _, fileHeader := range files {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return NewAPIError(err, 400)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return NewAPIError(err, 400)
+		}
+		readers = append(readers, bytes.NewReader(data))
+	}
+
+	var buf bytes.Buffer
+	if err := pdfapi.MergeRaw(readers, &buf, false, newPDFConfig(r.FormValue("password"))); err != nil {
+		return NewAPIError(fmt.Errorf("could not merge PDFs: %w", err), 400)
+	}
+
+	return writePDF(w, buf.Bytes(), pdfDownloadName(r, "merged"))
+}
+
+// handleSplitPDF returns a ZIP with one PDF per comma separated range,
+// e.g. ranges=1-3,4,5- gives three documents
+func (sv *APIServer) handleSplitPDF(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseMultipartForm(55 << 20); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	rs, err := readUploadedPDF(r, "pdf")
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	ranges, err := parsePageSelection(r.FormValue("ranges"))
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	conf := newPDFConfig(r.FormValue("password"))
+	name := strings.TrimSuffix(pdfDownloadName(r, "split"), ".pdf")
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, pageRange := range ranges {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return NewAPIError(err, 500)
+		}
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     fmt.Sprintf("%s-%d.pdf", name, i+1),
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return NewAPIError(err, 500)
+		}
+		if err := pdfapi.Collect(rs, fw, []string{pageRange}, conf); err != nil {
+			return NewAPIError(fmt.Errorf("could not extract pages %s: %w", pageRange, err), 400)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return NewAPIError(err, 500)
+	}
+
+	return writeAttachment(w, buf.Bytes(), "application/zip", name+".zip")
+}
+
+// handlePagesPDF rebuilds the document with the pages listed in order, pages
+// left out are deleted and pages listed twice are duplicated
+func (sv *APIServer) handlePagesPDF(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseMultipartForm(55 << 20); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	rs, err := readUploadedPDF(r, "pdf")
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	order, err := parsePageSelection(r.FormValue("order"))
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	var buf bytes.Buffer
+	if err := pdfapi.Collect(rs, &buf, order, newPDFConfig(r.FormValue("password"))); err != nil {
+		return NewAPIError(fmt.Errorf("could not reorder pages: %w", err), 400)
+	}
+
+	return writePDF(w, buf.Bytes(), pdfDownloadName(r, "slurptools"))
+}
+
+// handleRotatePDF rotates the selected pages clockwise, all pages when none are given
+func (sv *APIServer) handleRotatePDF(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseMultipartForm(55 << 20); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	rs, err := readUploadedPDF(r, "pdf")
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	rotation, err := strconv.Atoi(r.FormValue("rotation"))
+	if err != nil || rotation%90 != 0 {
+		return NewAPIError("rotation must be a multiple of 90", 400)
+	}
+
+	var pages []string
+	if v := r.FormValue("pages"); v != "" {
+		pages, err = parsePageSelection(v)
+		if err != nil {
+			return NewAPIError(err, 400)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdfapi.Rotate(rs, &buf, rotation, pages, newPDFConfig(r.FormValue("password"))); err != nil {
+		return NewAPIError(fmt.Errorf("could not rotate pages: %w", err), 400)
+	}
+
+	return writePDF(w, buf.Bytes(), pdfDownloadName(r, "slurptools"))
+}
+
+func parsePageSelection(s string) ([]string, error) {
+	s = strings.ReplaceAll(s, " ", "")
+	if s == "" {
+		return nil, fmt.Errorf("provide the pages to use, e.g. 1-3,5")
+	}
+
+	pages, err := pdfapi.ParsePageSelection(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page selection %q", s)
+	}
+	return pages, nil
+}
+
+func pdfDownloadName(r *http.Request, fallback string) string {
+	filename := r.FormValue("filename")
+	if filename == "" {
+		filename = fallback
+	}
+	return types.PDFOptions{Filename: filename}.DownloadName()
+}
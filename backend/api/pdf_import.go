@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/navalesnahuel/slurp-tools/types"
+	"github.com/navalesnahuel/slurp-tools/util"
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	_ "golang.org/x/image/tiff"
+)
+
+func init() {
+	// pdfcpu would otherwise create a config directory in the user's home
+	model.ConfigPath = "disable"
+}
+
+func newPDFConfig(password string) *model.Configuration {
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	conf.UserPW = password
+	conf.OwnerPW = password
+	return conf
+}
+
+func readUploadedPDF(r *http.Request, field string) (io.ReadSeeker, error) {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (sv *APIServer) handleImportPDF(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseMultipartForm(55 << 20); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	rs, err := readUploadedPDF(r, "pdf")
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	conf := newPDFConfig(r.FormValue("password"))
+	conf.Cmd = model.EXTRACTIMAGES
+	pdfCtx, err := pdfapi.ReadValidateAndOptimize(rs, conf)
+	if err != nil {
+		return NewAPIError(fmt.Errorf("could not read PDF: %w", err), 400)
+	}
+
+	result := types.PDFImportResult{Pages: []types.ImportedPage{}, Skipped: []int{}}
+	// walk the pages in order, pdfcpu's page selection is a map
+	for pageNr := 1; pageNr <= pdfCtx.PageCount; pageNr++ {
+		images, err := pdfcpu.ExtractPageImages(pdfCtx, pageNr, false)
+		if err != nil {
+			return NewAPIError(fmt.Errorf("could not read page %d: %w", pageNr, err), 400)
+		}
+
+		img, err := largestPageImage(images)
+		if err != nil || img == nil {
+			result.Skipped = append(result.Skipped, pageNr)
+			continue
+		}
+
+		imageProps, err := sv.imageStore.SaveVersion(r.Context(), uuid.NewString(), 0, img)
+		if err != nil {
+			return NewAPIError(err, 500)
+		}
+
+		result.Pages = append(result.Pages, types.ImportedPage{Page: pageNr, ImageVersion: imageProps})
+	}
+
+	return util.WriteJSON(w, 201, result)
+}
+
+// largestPageImage decodes the biggest raster image on a page, scanned pages
+// usually carry a single full page image next to small logos or thumbnails
+func largestPageImage(images map[int]model.Image) (image.Image, error) {
+	var best *model.Image
+	for _, img := range images {
+		if img.Thumb {
+			continue
+		}
+		if best == nil || img.Width*img.Height > best.Width*best.Height {
+			img := img
+			best = &img
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	decoded, _, err := image.Decode(best)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s image %s: %w", best.FileType, best.Name, err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+
+	"github.com/disintegration/gift"
+	"github.com/gorilla/mux"
+	"github.com/navalesnahuel/slurp-tools/types"
+)
+
+const (
+	defaultPreviewSize    = 1024
+	defaultPreviewQuality = 80
+)
+
+type previewProxy struct {
+	image image.Image
+	scale float64 // proxy size over full size
+}
+
+// handlePreview renders the filter chain on a downscaled proxy of the current
+// version and streams it back as a JPEG without storing anything. Posting the
+// same chain to /image/filter/{image_id} commits it at full resolution.
+func (sv *APIServer) handlePreview(w http.ResponseWriter, r *http.Request) error {
+	imageID := mux.Vars(r)["image_id"]
+
+	size, err := queryInt(r, "size", defaultPreviewSize, 64, 4096)
+	if err != nil {
+		return err
+	}
+	quality, err := queryInt(r, "quality", defaultPreviewQuality, 1, 100)
+	if err != nil {
+		return err
+	}
+
+	var filterRequests []types.FilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&filterRequests); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	proxy, err := sv.previewProxy(r.Context(), imageID, size)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	// pixel sized parameters shrink with the image so the preview matches
+	// what the full resolution render will look like
+	scaled, err := types.ScaleFilters(filterRequests, proxy.scale)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	rendered, err := sv.runFilters(r.Context(), scaled, proxy.image, nil)
+	if err != nil {
+		return NewAPIError(err, 500)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	return jpeg.Encode(w, rendered, &jpeg.Options{Quality: quality})
+}
+
+// previewProxy returns the current version downscaled so its longest side is
+// at most size, cached until the image changes
+func (sv *APIServer) previewProxy(ctx context.Context, imageID string, size int) (previewProxy, error) {
+	v, recipe, err := sv.imageStore.CurrentRecipe(imageID)
+	if err != nil {
+		return previewProxy{}, err
+	}
+	keys := recipeKeys(imageID, recipe)
+	key := fmt.Sprintf("%s/proxy:%d", keys[len(keys)-1], size)
+
+	if cached, ok := sv.renders.Get(key); ok {
+		return cached.(previewProxy), nil
+	}
+
+	// the raster of the version the key was made from, not whatever is
+	// current by now
+	img, err := sv.imageStore.LoadImage(v.FilePath)
+	if err != nil {
+		return previewProxy{}, err
+	}
+
+	proxy := previewProxy{image: img, scale: 1}
+	bounds := img.Bounds()
+	if max(bounds.Dx(), bounds.Dy()) > size {
+		resize := gift.Resize(size, 0, gift.LinearResampling)
+		if bounds.Dy() > bounds.Dx() {
+			resize = gift.Resize(0, size, gift.LinearResampling)
+		}
+		release, err := sv.acquire(ctx, bounds, resize)
+		if err != nil {
+			return previewProxy{}, err
+		}
+		defer release()
+		proxy.image = types.ApplyFilters(img, resize)
+		proxy.scale = float64(proxy.image.Bounds().Dx()) / float64(bounds.Dx())
+	}
+
+	sv.renders.Add(key, proxy, rasterSize(proxy.image))
+	return proxy, nil
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// queryInt reads an integer query parameter, def when it is missing
+func queryInt(r *http.Request, name string, def, lo, hi int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		return 0, NewAPIError(fmt.Errorf("%s must be a number between %d and %d", name, lo, hi), 400)
+	}
+	return n, nil
+}
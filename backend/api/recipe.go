@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/navalesnahuel/slurp-tools/types"
+	"github.com/navalesnahuel/slurp-tools/util"
+)
+
+// Recipe endpoints change one step of the current version's recipe and render
+// the result from the base raster as a new version, so undo keeps working.
+
+func (sv *APIServer) handleGetRecipe(w http.ResponseWriter, r *http.Request) error {
+	version, recipe, err := sv.imageStore.CurrentRecipe(mux.Vars(r)["image_id"])
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	return util.WriteJSON(w, 200, types.RecipeResult{Version: version, Recipe: recipe})
+}
+
+// handleRecipeStep replaces the step's filter on PUT and removes it on DELETE
+func (sv *APIServer) handleRecipeStep(w http.ResponseWriter, r *http.Request) error {
+	from, recipe, idx, err := sv.recipeStep(r)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var fr types.FilterRequest
+		if err := json.NewDecoder(r.Body).Decode(&fr); err != nil {
+			return NewAPIError(err, 400)
+		}
+		recipe.Steps[idx].FilterRequest = fr
+	case http.MethodDelete:
+		recipe.Steps = append(recipe.Steps[:idx], recipe.Steps[idx+1:]...)
+	default:
+		return ErrorMethodNotAllowed
+	}
+
+	return sv.writeRecipeRender(w, r, from, recipe)
+}
+
+func (sv *APIServer) handleMoveRecipeStep(w http.ResponseWriter, r *http.Request) error {
+	from, recipe, idx, err := sv.recipeStep(r)
+	if err != nil {
+		return err
+	}
+
+	var req types.MoveStepRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return NewAPIError(err, 400)
+	}
+	if req.Position < 0 || req.Position >= len(recipe.Steps) {
+		return NewAPIError(fmt.Errorf("position must be between 0 and %d", len(recipe.Steps)-1), 400)
+	}
+
+	step := recipe.Steps[idx]
+	recipe.Steps = append(recipe.Steps[:idx], recipe.Steps[idx+1:]...)
+	recipe.Steps = append(recipe.Steps[:req.Position], append([]types.RecipeStep{step}, recipe.Steps[req.Position:]...)...)
+
+	return sv.writeRecipeRender(w, r, from, recipe)
+}
+
+func (sv *APIServer) recipeStep(r *http.Request) (types.ImageVersion, types.Recipe, int, error) {
+	vars := mux.Vars(r)
+
+	version, recipe, err := sv.imageStore.CurrentRecipe(vars["image_id"])
+	if err != nil {
+		return types.ImageVersion{}, types.Recipe{}, 0, NewAPIError(err, 400)
+	}
+
+	idx, err := recipe.StepIndex(vars["step_id"])
+	if err != nil {
+		return types.ImageVersion{}, types.Recipe{}, 0, NewAPIError(err, 404)
+	}
+	return version, recipe, idx, nil
+}
+
+func (sv *APIServer) writeRecipeRender(w http.ResponseWriter, r *http.Request, from types.ImageVersion, recipe types.Recipe) error {
+	// validated as a whole so errors point at the step index
+	if _, err := types.CreateFilters(recipe.Filters()); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	version, err := sv.renderRecipe(r.Context(), from, recipe)
+	if err != nil {
+		return NewAPIError(err, 500)
+	}
+
+	return util.WriteJSON(w, 201, types.RecipeResult{Version: version, Recipe: recipe})
+}
+
+// renderRecipe renders recipe, an edit of the recipe of version from, from
+// the longest cached prefix of its steps, or from the base raster, and stores
+// the result as a new version
+func (sv *APIServer) renderRecipe(ctx context.Context, from types.ImageVersion, recipe types.Recipe) (types.ImageVersion, error) {
+	imageID := from.UUID
+	keys := recipeKeys(imageID, recipe)
+
+	start := len(recipe.Steps)
+	var img image.Image
+	for ; start >= 0; start-- {
+		if cached, ok := sv.renders.Get(keys[start]); ok {
+			img = cached.(image.Image)
+			break
+		}
+	}
+
+	if img == nil {
+		base, err := sv.imageStore.LoadVersion(imageID, recipe.Base)
+		if err != nil {
+			return types.ImageVersion{}, err
+		}
+		start, img = 0, base
+		sv.renders.Add(keys[0], base, rasterSize(base))
+	}
+
+	rendered, err := sv.runFilters(ctx, recipe.Filters()[start:], img, func(i int, img image.Image) {
+		sv.renders.Add(keys[start+i+1], img, rasterSize(img))
+	})
+	if err != nil {
+		return types.ImageVersion{}, err
+	}
+
+	return sv.imageStore.SaveRecipeVersion(ctx, imageID, from.Generation, rendered, recipe)
+}
+
+// recipeKeys returns the cache key of the render after each prefix of the
+// steps, keys[0] being the base raster. Step ids do not change the output and
+// are left out. Keys start with renderPrefix(imageID).
+func recipeKeys(imageID string, recipe types.Recipe) []string {
+	keys := make([]string, 0, len(recipe.Steps)+1)
+	prefix := renderPrefix(imageID) + "recipe:"
+
+	h := sha256.New()
+	h.Write([]byte(recipe.Origin))
+	keys = append(keys, prefix+hex.EncodeToString(h.Sum(nil)))
+
+	for _, step := range recipe.Steps {
+		var params bytes.Buffer
+		if err := json.Compact(&params, step.Params); err != nil {
+			params.Write(step.Params)
+		}
+		h.Write([]byte{0})
+		h.Write([]byte(step.Filter))
+		h.Write([]byte{0})
+		h.Write(params.Bytes())
+		keys = append(keys, prefix+hex.EncodeToString(h.Sum(nil)))
+	}
+	return keys
+}
+
+// renderPrefix starts the key of every cached render of imageID
+func renderPrefix(imageID string) string {
+	return imageID + "/"
+}
+
+// rasterSize approximates the memory held by a decoded image
+func rasterSize(img image.Image) int64 {
+	b := img.Bounds()
+	return int64(b.Dx()) * int64(b.Dy()) * 4
+}
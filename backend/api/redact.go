@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/navalesnahuel/slurp-tools/audit"
+	"github.com/navalesnahuel/slurp-tools/types"
+	"github.com/navalesnahuel/slurp-tools/util"
+)
+
+// handleRedact paints the regions over the current version with a solid color
+// and makes the result the image's only version. Unlike filters a redaction
+// cannot be undone: the older versions, the files derived from them and their
+// cached renders are deleted. The raster is written to a new file by the
+// standard library encoder, which keeps no EXIF or other metadata.
+func (sv *APIServer) handleRedact(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return ErrorMethodNotAllowed
+	}
+	imageID := mux.Vars(r)["image_id"]
+
+	var req types.RedactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return NewAPIError(err, 400)
+	}
+	if err := req.Validate(); err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	img, from, err := sv.imageStore.LoadCurrent(imageID)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	version, discarded, err := sv.imageStore.ReplaceHistory(r.Context(), imageID, from.Generation, types.Redact(img, req))
+	if err != nil {
+		return NewAPIError(err, 500)
+	}
+	sv.renders.RemovePrefix(renderPrefix(imageID))
+
+	err = sv.audit.Record(audit.Event{
+		Action:     "redact",
+		RequestID:  RequestID(r.Context()),
+		RemoteAddr: r.RemoteAddr,
+		UUID:       imageID,
+		Details: map[string]any{
+			"regions":   req.Regions,
+			"discarded": discarded,
+		},
+	})
+	if err != nil {
+		// the pixels are gone either way, do not report the redaction as failed
+		slog.Error("audit log write failed", "request_id", RequestID(r.Context()), "uuid", imageID, "error", err)
+	}
+
+	return util.WriteJSON(w, http.StatusCreated, types.RedactResult{Version: version, Discarded: discarded})
+}
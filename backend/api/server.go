@@ -5,21 +5,40 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/navalesnahuel/slurp-tools/audit"
+	"github.com/navalesnahuel/slurp-tools/cache"
+	"github.com/navalesnahuel/slurp-tools/health"
+	"github.com/navalesnahuel/slurp-tools/jobs"
+	"github.com/navalesnahuel/slurp-tools/limiter"
+	"github.com/navalesnahuel/slurp-tools/scanner"
 	"github.com/navalesnahuel/slurp-tools/storage"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type APIServer struct {
 	listenAddr string
 	store      storage.Storer
 	imageStore storage.ImageStorer
+	jobs       *jobs.Queue
+	limiter    *limiter.Limiter
+	scanner    *scanner.Client
+	health     *health.Checker
+	renders    *cache.LRU // intermediate recipe renders
+	audit      *audit.Log
 	// environment map[string]string
 }
 
-func NewServer(listenAddr string, store storage.Storer, imgStore storage.ImageStorer) *APIServer {
+func NewServer(listenAddr string, store storage.Storer, imgStore storage.ImageStorer, jobQueue *jobs.Queue, processLimiter *limiter.Limiter, scannerClient *scanner.Client, checker *health.Checker, renderCache *cache.LRU, auditLog *audit.Log) *APIServer {
 	return &APIServer{
 		listenAddr: listenAddr,
 		store:      store,
 		imageStore: imgStore,
+		jobs:       jobQueue,
+		limiter:    processLimiter,
+		scanner:    scannerClient,
+		health:     checker,
+		renders:    renderCache,
+		audit:      auditLog,
 	}
 }
 
@@ -27,13 +46,37 @@ func (s *APIServer) RunServer() {
 	fmt.Printf("server running and listening at localhost%s\n", s.listenAddr)
 	router := mux.NewRouter()
 
+	router.HandleFunc("/healthz", Handlers(s.handleHealth))
+	router.HandleFunc("/readyz", Handlers(s.handleReady))
+	router.Handle("/metrics", promhttp.Handler())
 	router.HandleFunc("/image/upload", Handlers(s.handleUploadImage))
 	router.HandleFunc("/image/scan", Handlers(s.handleScanner))
 	router.HandleFunc("/image/pdf", Handlers(s.handlerImageToPDF))
+	router.HandleFunc("/pdf", Handlers(s.handleStoredImagesToPDF))
+	router.HandleFunc("/pdf/import", Handlers(s.handleImportPDF))
+	router.HandleFunc("/pdf/merge", Handlers(s.handleMergePDF))
+	router.HandleFunc("/pdf/split", Handlers(s.handleSplitPDF))
+	router.HandleFunc("/pdf/pages", Handlers(s.handlePagesPDF))
+	router.HandleFunc("/pdf/rotate", Handlers(s.handleRotatePDF))
+	router.HandleFunc("/export", Handlers(s.handleExport))
+	router.HandleFunc("/jobs/filter/{image_id}", Handlers(s.handleFilterJob))
+	router.HandleFunc("/jobs/scan", Handlers(s.handleScanJob))
+	router.HandleFunc("/jobs/pdf", Handlers(s.handlePDFJob))
+	router.HandleFunc("/jobs/{job_id}", Handlers(s.handleJobStatus))
+	router.HandleFunc("/jobs/{job_id}/cancel", Handlers(s.handleCancelJob))
+	router.HandleFunc("/jobs/{job_id}/result", Handlers(s.handleJobResult))
 	router.HandleFunc("/image/filter/{image_id}", Handlers(s.handleApplyFilterToImage))
+	router.HandleFunc("/images/filter", Handlers(s.handleBatchFilter))
 	router.HandleFunc("/image/{image_id}/undo", Handlers(s.handleUndo))
 	router.HandleFunc("/image/{image_id}/redo", Handlers(s.handleRedo))
 	router.HandleFunc("/image/{image_id}/download", Handlers(s.handleServeFile))
+	router.HandleFunc("/image/{image_id}/analysis", Handlers(s.handleImageAnalysis))
+	router.HandleFunc("/image/{image_id}/thumbnail", Handlers(s.handleThumbnail))
+	router.HandleFunc("/image/{image_id}/preview", Handlers(s.handlePreview))
+	router.HandleFunc("/image/{image_id}/redact", Handlers(s.handleRedact))
+	router.HandleFunc("/image/{image_id}/recipe", Handlers(s.handleGetRecipe))
+	router.HandleFunc("/image/{image_id}/recipe/{step_id}", Handlers(s.handleRecipeStep))
+	router.HandleFunc("/image/{image_id}/recipe/{step_id}/move", Handlers(s.handleMoveRecipeStep))
 
 	http.ListenAndServe(s.listenAddr, router)
 }
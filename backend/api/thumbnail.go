@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+
+	"github.com/disintegration/gift"
+	"github.com/gorilla/mux"
+	"github.com/navalesnahuel/slurp-tools/types"
+)
+
+const defaultThumbnailSize = 256
+
+// handleThumbnail serves the current version scaled to fit size x size
+func (sv *APIServer) handleThumbnail(w http.ResponseWriter, r *http.Request) error {
+	imageID := mux.Vars(r)["image_id"]
+
+	size, err := queryInt(r, "size", defaultThumbnailSize, 16, 1024)
+	if err != nil {
+		return err
+	}
+	format, err := queryFormat(r, "jpeg", "jpeg", "webp")
+	if err != nil {
+		return err
+	}
+
+	v, err := sv.imageStore.Current(imageID)
+	if err != nil {
+		return NewAPIError(err, 400)
+	}
+
+	return sv.serveDerived(w, r, v, fmt.Sprintf("thumb%d.%s", size, format), format, func() (image.Image, error) {
+		img, err := sv.imageStore.LoadImage(v.FilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		bounds := img.Bounds()
+		if bounds.Dx() > size || bounds.Dy() > size {
+			resize := gift.ResizeToFit(size, size, gift.LanczosResampling)
+			release, err := sv.acquire(r.Context(), bounds, resize)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			img = types.ApplyFilters(img, resize)
+		}
+		return img, nil
+	})
+}
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Event is one line of the audit log
+type Event struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	RequestID  string    `json:"request_id,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UUID       string    `json:"uuid"`
+	Details    any       `json:"details,omitempty"`
+}
+
+// Log appends events as JSON lines. Unlike the request log it is written
+// synchronously and reports failures, callers decide what a lost entry means.
+type Log struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func New(w io.Writer) *Log {
+	return &Log{w: w}
+}
+
+// Open appends to the file at path, creating it and its directory if needed
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return New(f), nil
+}
+
+func (l *Log) Record(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+type entry struct {
+	key   string
+	value any
+	size  int64
+}
+
+// LRU keeps the most recently used values while their total size stays under
+// a byte budget. Values are shared, callers must not modify them.
+type LRU struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func NewLRU(maxBytes int64) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Add stores value, evicting the least recently used entries to make room.
+// Values larger than the whole budget are not cached.
+func (c *LRU) Add(key string, value any, size int64) {
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		c.used += size - e.size
+		e.value, e.size = value, size
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&entry{key: key, value: value, size: size})
+		c.used += size
+	}
+
+	for c.used > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// RemovePrefix drops every entry whose key starts with prefix
+func (c *LRU) RemovePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(el)
+		}
+	}
+}
+
+func (c *LRU) removeElement(el *list.Element) {
+	e := el.Value.(*entry)
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	c.used -= e.size
+}
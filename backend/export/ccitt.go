@@ -0,0 +1,181 @@
+package export
+
+import (
+	"image"
+)
+
+// CCITT T.6 (Group 4) run length codes, see ITU-T T.4 tables 2 and 3
+var whiteTermCodes = [64]string{
+	"00110101", "000111", "0111", "1000", "1011", "1100", "1110", "1111",
+	"10011", "10100", "00111", "01000", "001000", "000011", "110100", "110101",
+	"101010", "101011", "0100111", "0001100", "0001000", "0010111", "0000011", "0000100",
+	"0101000", "0101011", "0010011", "0100100", "0011000", "00000010", "00000011", "00011010",
+	"00011011", "00010010", "00010011", "00010100", "00010101", "00010110", "00010111", "00101000",
+	"00101001", "00101010", "00101011", "00101100", "00101101", "00000100", "00000101", "00001010",
+	"00001011", "01010010", "01010011", "01010100", "01010101", "00100100", "00100101", "01011000",
+	"01011001", "01011010", "01011011", "01001010", "01001011", "00110010", "00110011", "00110100",
+}
+
+// runs of 64 to 1728 in steps of 64
+var whiteMakeupCodes = [27]string{
+	"11011", "10010", "010111", "0110111", "00110110", "00110111", "01100100", "01100101",
+	"01101000", "01100111", "011001100", "011001101", "011010010", "011010011", "011010100", "011010101",
+	"011010110", "011010111", "011011000", "011011001", "011011010", "011011011", "010011000", "010011001",
+	"010011010", "011000", "010011011",
+}
+
+var blackTermCodes = [64]string{
+	"0000110111", "010", "11", "10", "011", "0011", "0010", "00011",
+	"000101", "000100", "0000100", "0000101", "0000111", "00000100", "00000111", "000011000",
+	"0000010111", "0000011000", "0000001000", "00001100111", "00001101000", "00001101100", "00000110111", "00000101000",
+	"00000010111", "00000011000", "000011001010", "000011001011", "000011001100", "000011001101", "000001101000", "000001101001",
+	"000001101010", "000001101011", "000011010010", "000011010011", "000011010100", "000011010101", "000011010110", "000011010111",
+	"000001101100", "000001101101", "000011011010", "000011011011", "000001010100", "000001010101", "000001010110", "000001010111",
+	"000001100100", "000001100101", "000001010010", "000001010011", "000000100100", "000000110111", "000000111000", "000000100111",
+	"000000101000", "000001011000", "000001011001", "000000101011", "000000101100", "000001011010", "000001100110", "000001100111",
+}
+
+var blackMakeupCodes = [27]string{
+	"0000001111", "000011001000", "000011001001", "000001011011", "000000110011", "000000110100", "000000110101", "0000001101100",
+	"0000001101101", "0000001001010", "0000001001011", "0000001001100", "0000001001101", "0000001110010", "0000001110011", "0000001110100",
+	"0000001110101", "0000001110110", "0000001110111", "0000001010010", "0000001010011", "0000001010100", "0000001010101", "0000001011010",
+	"0000001011011", "0000001100100", "0000001100101",
+}
+
+// runs of 1792 to 2560 in steps of 64, shared by both colors
+var extMakeupCodes = [13]string{
+	"00000001000", "00000001100", "00000001101", "000000010010", "000000010011", "000000010100", "000000010101",
+	"000000010110", "000000010111", "000000011100", "000000011101", "000000011110", "000000011111",
+}
+
+// Mode codes, vertical ones indexed by b1 - a1 + 3
+var (
+	passCode       = "0001"
+	horizontalCode = "001"
+	verticalCodes  = [7]string{"0000011", "000011", "011", "1", "010", "000010", "0000010"}
+	eofbCode       = "000000000001000000000001"
+)
+
+type bitWriter struct {
+	buf   []byte
+	cur   byte
+	nBits uint
+}
+
+func (b *bitWriter) writeCode(code string) {
+	for i := 0; i < len(code); i++ {
+		b.cur <<= 1
+		if code[i] == '1' {
+			b.cur |= 1
+		}
+		b.nBits++
+		if b.nBits == 8 {
+			b.buf = append(b.buf, b.cur)
+			b.cur, b.nBits = 0, 0
+		}
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	if b.nBits > 0 {
+		b.buf = append(b.buf, b.cur<<(8-b.nBits))
+		b.cur, b.nBits = 0, 0
+	}
+	return b.buf
+}
+
+func (b *bitWriter) writeRun(run int, black bool) {
+	term, makeup := whiteTermCodes[:], whiteMakeupCodes[:]
+	if black {
+		term, makeup = blackTermCodes[:], blackMakeupCodes[:]
+	}
+
+	for run >= 2624 {
+		b.writeCode(extMakeupCodes[len(extMakeupCodes)-1])
+		run -= 2560
+	}
+	if run >= 1792 {
+		b.writeCode(extMakeupCodes[run/64-28])
+		run %= 64
+	} else if run >= 64 {
+		b.writeCode(makeup[run/64-1])
+		run %= 64
+	}
+	b.writeCode(term[run])
+}
+
+// nextChange returns the first position at or after start whose color differs
+// from color, or the line width when there is none
+func nextChange(line []bool, start int, color bool) int {
+	for i := start; i < len(line); i++ {
+		if line[i] != color {
+			return i
+		}
+	}
+	return len(line)
+}
+
+func pixelAt(line []bool, i int) bool {
+	return i < len(line) && line[i]
+}
+
+// EncodeG4 compresses a bilevel image with CCITT Group 4, palette index 0 is
+// black. Bits are MSB first, as expected by TIFF FillOrder 1 and PDF.
+func EncodeG4(img *image.Paletted) []byte {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+
+	w := &bitWriter{}
+	ref := make([]bool, width)
+	cur := make([]bool, width)
+
+	for y := 0; y < bounds.Dy(); y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width]
+		for x, p := range row {
+			cur[x] = p == 0
+		}
+
+		a0 := 0
+		color := false
+		a1 := nextChange(cur, 0, false)
+		b1 := nextChange(ref, 0, false)
+		for {
+			b2 := nextChange(ref, b1, pixelAt(ref, b1))
+			if b1 >= width {
+				b2 = width
+			}
+
+			if b2 < a1 {
+				w.writeCode(passCode)
+				a0 = b2
+			} else if d := b1 - a1; d >= -3 && d <= 3 {
+				w.writeCode(verticalCodes[d+3])
+				a0 = a1
+				color = !color
+			} else {
+				a2 := width
+				if a1 < width {
+					a2 = nextChange(cur, a1, cur[a1])
+				}
+				w.writeCode(horizontalCode)
+				w.writeRun(a1-a0, color)
+				w.writeRun(a2-a1, !color)
+				a0 = a2
+			}
+
+			if a0 >= width {
+				break
+			}
+
+			color = cur[a0]
+			a1 = nextChange(cur, a0, color)
+			b1 = nextChange(ref, a0, !color)
+			b1 = nextChange(ref, b1, color)
+		}
+
+		ref, cur = cur, ref
+	}
+
+	w.writeCode(eofbCode)
+	return w.bytes()
+}
@@ -0,0 +1,234 @@
+package export
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"sort"
+
+	"github.com/navalesnahuel/slurp-tools/types"
+)
+
+// TIFF tags, field types and compression schemes used by the writer
+const (
+	tagNewSubfileType      = 254
+	tagImageWidth          = 256
+	tagImageLength         = 257
+	tagBitsPerSample       = 258
+	tagCompression         = 259
+	tagPhotometric         = 262
+	tagStripOffsets        = 273
+	tagSamplesPerPixel     = 277
+	tagRowsPerStrip        = 278
+	tagStripByteCounts     = 279
+	tagXResolution         = 282
+	tagYResolution         = 283
+	tagPlanarConfiguration = 284
+	tagResolutionUnit      = 296
+	tagPageNumber          = 297
+
+	fieldShort    = 3
+	fieldLong     = 4
+	fieldRational = 5
+
+	compressionG4      = 4
+	compressionDeflate = 8
+
+	photometricWhiteIsZero = 0
+	photometricBlackIsZero = 1
+	photometricRGB         = 2
+)
+
+type TIFFPage struct {
+	Image  image.Image
+	Format string // auto, bilevel, gray or rgb
+}
+
+type ifdEntry struct {
+	tag    uint16
+	typ    uint16
+	values []uint32 // rationals are stored as numerator, denominator pairs
+}
+
+type encodedPage struct {
+	data        []byte
+	width       int
+	height      int
+	bits        []uint32
+	compression uint32
+	photometric uint32
+}
+
+// WriteTIFF writes the pages as a little endian multi-page TIFF, bilevel pages
+// are compressed with CCITT Group 4 and the rest with Deflate
+func WriteTIFF(w io.Writer, pages []TIFFPage, dpi float64) error {
+	if len(pages) == 0 {
+		return fmt.Errorf("tiff: no pages to write")
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{'I', 'I', 42, 0, 0, 0, 0, 0})
+	nextIFDOffset := 4
+
+	resolution := []uint32{uint32(dpi * 100), 100}
+
+	for i, page := range pages {
+		enc, err := encodeTIFFPage(page)
+		if err != nil {
+			return err
+		}
+
+		stripOffset := buf.Len()
+		buf.Write(enc.data)
+		padWord(&buf)
+
+		entries := []ifdEntry{
+			{tagNewSubfileType, fieldLong, []uint32{2}},
+			{tagImageWidth, fieldLong, []uint32{uint32(enc.width)}},
+			{tagImageLength, fieldLong, []uint32{uint32(enc.height)}},
+			{tagBitsPerSample, fieldShort, enc.bits},
+			{tagCompression, fieldShort, []uint32{enc.compression}},
+			{tagPhotometric, fieldShort, []uint32{enc.photometric}},
+			{tagStripOffsets, fieldLong, []uint32{uint32(stripOffset)}},
+			{tagSamplesPerPixel, fieldShort, []uint32{uint32(len(enc.bits))}},
+			{tagRowsPerStrip, fieldLong, []uint32{uint32(enc.height)}},
+			{tagStripByteCounts, fieldLong, []uint32{uint32(len(enc.data))}},
+			{tagXResolution, fieldRational, resolution},
+			{tagYResolution, fieldRational, resolution},
+			{tagPlanarConfiguration, fieldShort, []uint32{1}},
+			{tagResolutionUnit, fieldShort, []uint32{2}},
+			{tagPageNumber, fieldShort, []uint32{uint32(i), uint32(len(pages))}},
+		}
+
+		ifdOffset := writeIFD(&buf, entries)
+		binary.LittleEndian.PutUint32(buf.Bytes()[nextIFDOffset:], uint32(ifdOffset))
+		nextIFDOffset = buf.Len() - 4
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func encodeTIFFPage(page TIFFPage) (encodedPage, error) {
+	img := page.Image
+	bounds := img.Bounds()
+	enc := encodedPage{width: bounds.Dx(), height: bounds.Dy()}
+
+	format := page.Format
+	if format == "" || format == types.ExportFormatAuto {
+		format = types.ExportFormatRGB
+		if types.IsBilevel(img) {
+			format = types.ExportFormatBilevel
+		}
+	}
+
+	var raw []byte
+	switch format {
+	case types.ExportFormatBilevel:
+		enc.data = EncodeG4(types.Binarize(img))
+		enc.bits = []uint32{1}
+		enc.compression = compressionG4
+		enc.photometric = photometricWhiteIsZero
+		return enc, nil
+	case types.ExportFormatGray:
+		gray := image.NewGray(image.Rect(0, 0, enc.width, enc.height))
+		draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+		raw = gray.Pix
+		enc.bits = []uint32{8}
+		enc.photometric = photometricBlackIsZero
+	case types.ExportFormatRGB:
+		rgba := image.NewRGBA(image.Rect(0, 0, enc.width, enc.height))
+		draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+		raw = make([]byte, 0, enc.width*enc.height*3)
+		for i := 0; i < len(rgba.Pix); i += 4 {
+			raw = append(raw, rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+		}
+		enc.bits = []uint32{8, 8, 8}
+		enc.photometric = photometricRGB
+	default:
+		return enc, fmt.Errorf("tiff: unsupported page format %q", format)
+	}
+
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	if _, err := zw.Write(raw); err != nil {
+		return enc, err
+	}
+	if err := zw.Close(); err != nil {
+		return enc, err
+	}
+	enc.data = data.Bytes()
+	enc.compression = compressionDeflate
+	return enc, nil
+}
+
+// writeIFD appends the directory and any values that do not fit in the entries,
+// returning the directory offset. The next IFD offset is left as zero.
+func writeIFD(buf *bytes.Buffer, entries []ifdEntry) int {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// values larger than 4 bytes go before the directory
+	offsets := make([]uint32, len(entries))
+	for i, e := range entries {
+		if valueSize(e) <= 4 {
+			continue
+		}
+		offsets[i] = uint32(buf.Len())
+		buf.Write(encodeValues(e))
+		padWord(buf)
+	}
+
+	ifdOffset := buf.Len()
+	var b [12]byte
+	binary.LittleEndian.PutUint16(b[:2], uint16(len(entries)))
+	buf.Write(b[:2])
+
+	for i, e := range entries {
+		count := len(e.values)
+		if e.typ == fieldRational {
+			count /= 2
+		}
+		binary.LittleEndian.PutUint16(b[0:], e.tag)
+		binary.LittleEndian.PutUint16(b[2:], e.typ)
+		binary.LittleEndian.PutUint32(b[4:], uint32(count))
+		clear(b[8:])
+		if valueSize(e) <= 4 {
+			copy(b[8:], encodeValues(e))
+		} else {
+			binary.LittleEndian.PutUint32(b[8:], offsets[i])
+		}
+		buf.Write(b[:])
+	}
+
+	buf.Write([]byte{0, 0, 0, 0})
+	return ifdOffset
+}
+
+func valueSize(e ifdEntry) int {
+	if e.typ == fieldShort {
+		return 2 * len(e.values)
+	}
+	return 4 * len(e.values)
+}
+
+func encodeValues(e ifdEntry) []byte {
+	out := make([]byte, valueSize(e))
+	for i, v := range e.values {
+		if e.typ == fieldShort {
+			binary.LittleEndian.PutUint16(out[2*i:], uint16(v))
+		} else {
+			binary.LittleEndian.PutUint32(out[4*i:], v)
+		}
+	}
+	return out
+}
+
+func padWord(buf *bytes.Buffer) {
+	if buf.Len()%2 != 0 {
+		buf.WriteByte(0)
+	}
+}
@@ -0,0 +1,79 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/navalesnahuel/slurp-tools/types"
+)
+
+type ZIPEntry struct {
+	Name    string
+	Image   image.Image
+	Format  string // auto, png, jpeg or tiff
+	Quality int    // jpeg only
+	DPI     float64
+}
+
+var entryExtensions = map[string]string{
+	types.ExportFormatPNG:  ".png",
+	types.ExportFormatJPEG: ".jpg",
+	types.ExportFormatTIFF: ".tif",
+}
+
+// WriteZIP writes every image as its own file, duplicated names get a numeric suffix
+func WriteZIP(w io.Writer, entries []ZIPEntry) error {
+	zw := zip.NewWriter(w)
+	used := make(map[string]bool)
+
+	for i, entry := range entries {
+		format := entry.Format
+		if format == "" || format == types.ExportFormatAuto {
+			format = types.ExportFormatPNG
+		}
+		ext, ok := entryExtensions[format]
+		if !ok {
+			return fmt.Errorf("zip: unsupported entry format %q", format)
+		}
+
+		base := strings.TrimSuffix(path.Base(entry.Name), path.Ext(entry.Name))
+		if base == "" || base == "." || base == "/" {
+			base = fmt.Sprintf("page-%03d", i+1)
+		}
+		name := base + ext
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s-%d%s", base, n, ext)
+		}
+		used[name] = true
+
+		method := zip.Deflate
+		if format == types.ExportFormatJPEG {
+			method = zip.Store
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+
+		switch format {
+		case types.ExportFormatPNG:
+			err = png.Encode(fw, entry.Image)
+		case types.ExportFormatJPEG:
+			err = jpeg.Encode(fw, entry.Image, &jpeg.Options{Quality: entry.Quality})
+		case types.ExportFormatTIFF:
+			err = WriteTIFF(fw, []TIFFPage{{Image: entry.Image}}, entry.DPI)
+		}
+		if err != nil {
+			return fmt.Errorf("zip: could not encode %s: %w", name, err)
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,37 @@
+package health
+
+import (
+	"context"
+	"fmt"
+)
+
+type DiskUsage struct {
+	Path        string  `json:"path"`
+	FreeBytes   uint64  `json:"free_bytes"`
+	TotalBytes  uint64  `json:"total_bytes"`
+	FreePercent float64 `json:"free_percent"`
+}
+
+// DiskSpace checks that the filesystem holding path has at least minFreeBytes
+// and minFreePercent available, a zero threshold is not checked
+func DiskSpace(path string, minFreeBytes uint64, minFreePercent float64) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		free, total, err := diskSpace(path)
+		if err != nil {
+			return nil, err
+		}
+
+		usage := DiskUsage{Path: path, FreeBytes: free, TotalBytes: total}
+		if total > 0 {
+			usage.FreePercent = float64(free) / float64(total) * 100
+		}
+
+		if minFreeBytes > 0 && free < minFreeBytes {
+			return usage, fmt.Errorf("only %d MB free, need %d MB", free>>20, minFreeBytes>>20)
+		}
+		if minFreePercent > 0 && total > 0 && usage.FreePercent < minFreePercent {
+			return usage, fmt.Errorf("only %.1f%% free, need %.1f%%", usage.FreePercent, minFreePercent)
+		}
+		return usage, nil
+	}
+}
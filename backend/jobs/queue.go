@@ -0,0 +1,271 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrQueueFull   = errors.New("the job queue is full")
+	ErrNotFound    = errors.New("job not found")
+	ErrNotFinished = errors.New("job has not finished yet")
+	ErrFinished    = errors.New("job has already finished")
+	ErrPanicked    = errors.New("job failed unexpectedly")
+)
+
+type Status string
+
+const (
+	StatusQueued   Status = "queued"
+	StatusRunning  Status = "running"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+)
+
+// Result is what a finished job produced, served as is by the result endpoint
+type Result struct {
+	ContentType string
+	Filename    string
+	Data        []byte
+}
+
+// Func runs the job, it should return early once ctx is canceled
+type Func func(ctx context.Context, job *Job) (*Result, error)
+
+type Job struct {
+	mu         sync.Mutex
+	id         string
+	kind       string
+	status     Status
+	progress   float64
+	err        string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	result     *Result
+	fn         Func
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// Info is the public snapshot of a job
+type Info struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     Status     `json:"status"`
+	Progress   float64    `json:"progress"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// SetProgress records how much of the job is done, from 0 to 1
+func (j *Job) SetProgress(p float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = min(max(p, 0), 1)
+}
+
+func (j *Job) Info() Info {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := Info{
+		ID:        j.id,
+		Type:      j.kind,
+		Status:    j.status,
+		Progress:  j.progress,
+		Error:     j.err,
+		CreatedAt: j.createdAt,
+	}
+	if !j.startedAt.IsZero() {
+		started := j.startedAt
+		info.StartedAt = &started
+	}
+	if !j.finishedAt.IsZero() {
+		finished := j.finishedAt
+		info.FinishedAt = &finished
+	}
+	return info
+}
+
+func (j *Job) finished() bool {
+	return j.status == StatusDone || j.status == StatusFailed || j.status == StatusCanceled
+}
+
+type Queue struct {
+	mu      sync.RWMutex
+	jobs    map[string]*Job
+	pending chan *Job
+	ttl     time.Duration
+}
+
+// minTTL keeps the janitor's ticker at a sane interval
+const minTTL = time.Second
+
+// NewQueue starts workers goroutines that run up to capacity queued jobs,
+// finished jobs and their results are dropped after ttl. Values below one
+// worker, one queued job or minTTL are raised to them.
+func NewQueue(workers, capacity int, ttl time.Duration) *Queue {
+	q := &Queue{
+		jobs:    make(map[string]*Job),
+		pending: make(chan *Job, max(capacity, 1)),
+		ttl:     max(ttl, minTTL),
+	}
+
+	for range max(workers, 1) {
+		go q.work()
+	}
+	go q.janitor()
+
+	return q
+}
+
+func (q *Queue) Submit(kind string, fn Func) (Info, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		id:        uuid.NewString(),
+		kind:      kind,
+		status:    StatusQueued,
+		createdAt: time.Now(),
+		fn:        fn,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case q.pending <- job:
+	default:
+		cancel()
+		return Info{}, ErrQueueFull
+	}
+
+	q.jobs[job.id] = job
+	return job.Info(), nil
+}
+
+func (q *Queue) Get(id string) (Info, error) {
+	job, err := q.job(id)
+	if err != nil {
+		return Info{}, err
+	}
+	return job.Info(), nil
+}
+
+// Cancel stops a queued or running job, its result is discarded
+func (q *Queue) Cancel(id string) (Info, error) {
+	job, err := q.job(id)
+	if err != nil {
+		return Info{}, err
+	}
+
+	job.mu.Lock()
+	if job.finished() {
+		job.mu.Unlock()
+		return job.Info(), ErrFinished
+	}
+	job.status = StatusCanceled
+	job.finishedAt = time.Now()
+	job.mu.Unlock()
+
+	job.cancel()
+	return job.Info(), nil
+}
+
+func (q *Queue) Result(id string) (*Result, error) {
+	job, err := q.job(id)
+	if err != nil {
+		return nil, err
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status != StatusDone {
+		return nil, ErrNotFinished
+	}
+	return job.result, nil
+}
+
+func (q *Queue) job(id string) (*Job, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+func (q *Queue) work() {
+	for job := range q.pending {
+		job.mu.Lock()
+		if job.status != StatusQueued {
+			job.mu.Unlock()
+			continue
+		}
+		job.status = StatusRunning
+		job.startedAt = time.Now()
+		job.mu.Unlock()
+
+		result, err := job.run()
+
+		job.mu.Lock()
+		if job.status == StatusRunning {
+			job.finishedAt = time.Now()
+			switch {
+			case err != nil:
+				job.status = StatusFailed
+				job.err = err.Error()
+			default:
+				job.status = StatusDone
+				job.progress = 1
+				job.result = result
+			}
+		}
+		job.fn = nil
+		job.mu.Unlock()
+		job.cancel()
+	}
+}
+
+// run calls the job's function, a panic fails the job instead of taking the
+// worker and the whole process down with it
+func (job *Job) run() (result *Result, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			slog.Error("job panicked", "job_id", job.id, "panic", p, "stack", string(debug.Stack()))
+			result, err = nil, ErrPanicked
+		}
+	}()
+	return job.fn(job.ctx, job)
+}
+
+func (q *Queue) janitor() {
+	ticker := time.NewTicker(q.ttl / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		q.mu.Lock()
+		for id, job := range q.jobs {
+			job.mu.Lock()
+			expired := job.finished() && time.Since(job.finishedAt) > q.ttl
+			job.mu.Unlock()
+			if expired {
+				delete(q.jobs, id)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
@@ -0,0 +1,126 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrBusy = errors.New("too many images are being processed")
+
+type waiter struct {
+	bytes int64
+	ready chan struct{}
+}
+
+// Limiter bounds how many operations run at once and how much memory they are
+// estimated to use. Waiters are served in arrival order so large requests are
+// not starved by a stream of small ones.
+type Limiter struct {
+	mu            sync.Mutex
+	maxConcurrent int
+	maxBytes      int64
+	maxWait       time.Duration
+	active        int
+	usedBytes     int64
+	waiters       []*waiter
+}
+
+// New creates a limiter, maxWait is how long a request may queue before it is
+// rejected with ErrBusy (zero rejects immediately)
+func New(maxConcurrent int, maxBytes int64, maxWait time.Duration) *Limiter {
+	return &Limiter{
+		maxConcurrent: max(maxConcurrent, 1),
+		maxBytes:      maxBytes,
+		maxWait:       maxWait,
+	}
+}
+
+// Acquire reserves a slot and bytes of the budget, the returned func gives them back.
+// Requests bigger than the whole budget are let through once nothing else runs.
+func (l *Limiter) Acquire(ctx context.Context, bytes int64) (func(), error) {
+	bytes = min(bytes, l.maxBytes)
+
+	l.mu.Lock()
+	if len(l.waiters) == 0 && l.fits(bytes) {
+		l.take(bytes)
+		l.mu.Unlock()
+		return l.releaser(bytes), nil
+	}
+
+	if l.maxWait <= 0 {
+		l.mu.Unlock()
+		return nil, ErrBusy
+	}
+
+	w := &waiter{bytes: bytes, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return l.releaser(bytes), nil
+	case <-timer.C:
+		err = ErrBusy
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.ready:
+		// granted while giving up, hand the reservation back
+		l.put(bytes)
+	default:
+		for i, other := range l.waiters {
+			if other == w {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				break
+			}
+		}
+		l.notify()
+	}
+	return nil, err
+}
+
+func (l *Limiter) releaser(bytes int64) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.put(bytes)
+		})
+	}
+}
+
+func (l *Limiter) fits(bytes int64) bool {
+	return l.active < l.maxConcurrent && (l.active == 0 || l.usedBytes+bytes <= l.maxBytes)
+}
+
+func (l *Limiter) take(bytes int64) {
+	l.active++
+	l.usedBytes += bytes
+}
+
+func (l *Limiter) put(bytes int64) {
+	l.active--
+	l.usedBytes -= bytes
+	l.notify()
+}
+
+// notify wakes waiters from the front of the queue while they fit
+func (l *Limiter) notify() {
+	for len(l.waiters) > 0 && l.fits(l.waiters[0].bytes) {
+		w := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.take(w.bytes)
+		close(w.ready)
+	}
+}
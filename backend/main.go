@@ -1,14 +1,106 @@
 package main
 
 import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
 	"github.com/navalesnahuel/slurp-tools/api"
+	"github.com/navalesnahuel/slurp-tools/audit"
+	"github.com/navalesnahuel/slurp-tools/cache"
+	"github.com/navalesnahuel/slurp-tools/health"
+	"github.com/navalesnahuel/slurp-tools/jobs"
+	"github.com/navalesnahuel/slurp-tools/limiter"
+	"github.com/navalesnahuel/slurp-tools/metrics"
+	"github.com/navalesnahuel/slurp-tools/scanner"
 	"github.com/navalesnahuel/slurp-tools/storage"
+	"github.com/navalesnahuel/slurp-tools/tracing"
+	"github.com/navalesnahuel/slurp-tools/util"
 )
 
 func main() {
+	if os.Getenv("SLURP_LOG_FORMAT") == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), os.Getenv("SLURP_TRACING"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	store := storage.NewMemStore()
 	imgStore := storage.NewImageStore()
+	jobQueue := jobs.NewQueue(
+		util.EnvInt("SLURP_JOB_WORKERS", runtime.NumCPU()),
+		util.EnvInt("SLURP_JOB_QUEUE_SIZE", 100),
+		util.EnvDuration("SLURP_JOB_TTL", time.Hour),
+	)
+
+	processLimiter := limiter.New(
+		util.EnvInt("SLURP_MAX_CONCURRENT_FILTERS", runtime.NumCPU()),
+		int64(util.EnvInt("SLURP_FILTER_MEMORY_MB", 1024))<<20,
+		util.EnvDuration("SLURP_FILTER_QUEUE_TIMEOUT", 30*time.Second),
+	)
+
+	scannerConfig := scanner.DefaultConfig()
+	if url := os.Getenv("SLURP_SCANNER_URL"); url != "" {
+		scannerConfig.URL = url
+	}
+	scannerConfig.Timeout = util.EnvDuration("SLURP_SCANNER_TIMEOUT", scannerConfig.Timeout)
+	scannerConfig.MaxRetries = util.EnvInt("SLURP_SCANNER_RETRIES", scannerConfig.MaxRetries)
+	scannerConfig.Cooldown = util.EnvDuration("SLURP_SCANNER_COOLDOWN", scannerConfig.Cooldown)
+	scannerClient := scanner.New(scannerConfig)
+
+	checker := health.NewChecker(util.EnvDuration("SLURP_READY_TIMEOUT", 2*time.Second))
+	checker.Add("storage", func(ctx context.Context) (any, error) {
+		if err := imgStore.CheckWritable(); err != nil {
+			return nil, err
+		}
+		return nil, store.CheckWritable()
+	})
+	checker.Add("scanner", func(ctx context.Context) (any, error) {
+		return scannerClient.Ping(ctx)
+	})
+	checker.Add("disk", health.DiskSpace(
+		"./tmp",
+		uint64(util.EnvInt("SLURP_MIN_FREE_DISK_MB", 512))<<20,
+		float64(util.EnvInt("SLURP_MIN_FREE_DISK_PERCENT", 5)),
+	))
+
+	metrics.RegisterStore(func() metrics.StoreStats {
+		images, versions := imgStore.Stats()
+		size, _ := storage.DirSize("./tmp")
+		return metrics.StoreStats{Images: images, Versions: versions, Bytes: size}
+	})
+
+	renderCache := cache.NewLRU(int64(util.EnvInt("SLURP_RENDER_CACHE_MB", 256)) << 20)
+
+	auditPath := os.Getenv("SLURP_AUDIT_LOG")
+	if auditPath == "" {
+		auditPath = "./tmp/audit.log"
+	}
+	auditLog, err := audit.Open(auditPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := api.NewServer(":3000", store, imgStore, jobQueue, processLimiter, scannerClient, checker, renderCache, auditLog)
+
+	// flush pending spans when the process is asked to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		server.RunServer()
+		stop()
+	}()
+	<-ctx.Done()
 
-	server := api.NewServer(":3000", store, imgStore)
-	server.RunServer()
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Println(err)
+	}
 }
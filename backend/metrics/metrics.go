@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "slurp"
+
+var (
+	RequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"route", "method", "status"})
+
+	FilterDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "filter_duration_seconds",
+		Help:      "Time spent applying a single filter to an image.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"filter"})
+
+	ScannerDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scanner_request_duration_seconds",
+		Help:      "Latency of every attempt to call the scanner service.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	})
+
+	ScannerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scanner_errors_total",
+		Help:      "Failed scanner calls by reason.",
+	}, []string{"reason"})
+)
+
+// StoreStats is a point in time view of the image store
+type StoreStats struct {
+	Images   int
+	Versions int
+	Bytes    int64
+}
+
+// RegisterStore exports gauges for the store, stats is called on every scrape
+func RegisterStore(stats func() StoreStats) {
+	prometheus.MustRegister(&storeCollector{stats: stats})
+}
+
+var (
+	imagesDesc   = prometheus.NewDesc(namespace+"_images_tracked", "Images with at least one stored version.", nil, nil)
+	versionsDesc = prometheus.NewDesc(namespace+"_image_versions", "Stored image versions, undo history included.", nil, nil)
+	bytesDesc    = prometheus.NewDesc(namespace+"_storage_bytes", "Bytes used on disk by stored files.", nil, nil)
+)
+
+type storeCollector struct {
+	stats func() StoreStats
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- imagesDesc
+	ch <- versionsDesc
+	ch <- bytesDesc
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(imagesDesc, prometheus.GaugeValue, float64(s.Images))
+	ch <- prometheus.MustNewConstMetric(versionsDesc, prometheus.GaugeValue, float64(s.Versions))
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(s.Bytes))
+}
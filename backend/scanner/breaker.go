@@ -0,0 +1,95 @@
+package scanner
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// breaker opens after threshold consecutive failures and rejects calls until
+// cooldown has passed, then lets a single trial call decide whether to close
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+}
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: max(threshold, 1), cooldown: cooldown}
+}
+
+// allow reports whether a call may go through and, when it may not, how long
+// until the breaker tries again
+func (b *breaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		wait := b.cooldown - time.Since(b.openedAt)
+		if wait > 0 {
+			return false, wait
+		}
+		b.state = stateHalfOpen
+		return true, 0
+	case stateHalfOpen:
+		// a trial call is already in flight
+		return false, b.cooldown
+	}
+	return true, 0
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = stateClosed
+	b.failures = 0
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= b.threshold {
+		b.state = stateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release gives up a half-open trial that ended without telling anything about
+// the scanner's health, such as a call canceled by the client
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == stateHalfOpen {
+		b.state = stateOpen
+		b.openedAt = time.Now().Add(-b.cooldown)
+	}
+}
+
+func (b *breaker) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state.String()
+}
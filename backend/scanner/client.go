@@ -0,0 +1,283 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/navalesnahuel/slurp-tools/metrics"
+	"github.com/navalesnahuel/slurp-tools/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrTimeout = errors.New("scanner did not answer in time")
+
+// CircuitOpenError is returned without calling the scanner while it is
+// considered down
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("scanner is unavailable, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// StatusError is an unexpected status code from the scanner
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("scanner responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *StatusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type Config struct {
+	URL              string
+	Timeout          time.Duration // per attempt
+	MaxRetries       int
+	Backoff          time.Duration // doubled after every retry
+	FailureThreshold int           // consecutive failures that open the circuit
+	Cooldown         time.Duration // how long the circuit stays open
+}
+
+func DefaultConfig() Config {
+	return Config{
+		URL:              "http://localhost:8000/scanner",
+		Timeout:          30 * time.Second,
+		MaxRetries:       2,
+		Backoff:          200 * time.Millisecond,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}
+}
+
+// Client talks to the perspective correction service
+type Client struct {
+	cfg     Config
+	http    *http.Client
+	breaker *breaker
+}
+
+func New(cfg Config) *Client {
+	return &Client{
+		cfg:     cfg,
+		http:    &http.Client{},
+		breaker: newBreaker(cfg.FailureThreshold, cfg.Cooldown),
+	}
+}
+
+// Scan sends the image and the four corner points to the scanner and returns
+// the straightened image. Transient failures are retried with backoff.
+func (c *Client) Scan(ctx context.Context, img image.Image, points [][]int) (_ image.Image, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "scanner.Scan")
+	defer func() { tracing.End(span, err) }()
+
+	body, contentType, err := encodeScanRequest(img, points)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := c.cfg.Backoff
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		if ok, wait := c.breaker.allow(); !ok {
+			// our own failures opened the circuit, report what went wrong
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			metrics.ScannerErrors.WithLabelValues("circuit_open").Inc()
+			return nil, &CircuitOpenError{RetryAfter: wait}
+		}
+
+		start := time.Now()
+		scanned, err := c.do(ctx, attempt, body, contentType)
+		metrics.ScannerDuration.Observe(time.Since(start).Seconds())
+		if err != nil && ctx.Err() == nil {
+			metrics.ScannerErrors.WithLabelValues(errorReason(err)).Inc()
+		}
+		switch {
+		case err == nil:
+			c.breaker.success()
+			return scanned, nil
+		case ctx.Err() != nil:
+			c.breaker.release()
+			return nil, ctx.Err()
+		case !temporary(err):
+			// the scanner answered, it just did not like the request
+			c.breaker.success()
+			return nil, err
+		}
+
+		c.breaker.failure()
+		lastErr = err
+		if attempt >= c.cfg.MaxRetries {
+			return nil, err
+		}
+
+		// full jitter keeps retries from several requests apart
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff) + 1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) do(ctx context.Context, attempt int, body []byte, contentType string) (_ image.Image, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "scanner.attempt", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.Int("scanner.attempt", attempt),
+		attribute.String("url.full", c.cfg.URL),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		return nil, fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(msg))}
+	}
+
+	scanned, _, err := image.Decode(resp.Body)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		return nil, fmt.Errorf("could not decode image from scanner: %w", err)
+	}
+	return scanned, nil
+}
+
+// errorReason is the metrics label for a failed call
+func errorReason(err error) string {
+	var statusErr *StatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("status_%dxx", statusErr.StatusCode/100)
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "invalid_response"
+}
+
+func temporary(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.temporary()
+	}
+
+	var netErr net.Error
+	return errors.Is(err, ErrTimeout) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func encodeScanRequest(img image.Image, points [][]int) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, "", fmt.Errorf("could not encode image: %w", err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	imagePart, err := writer.CreateFormFile("file", "image.jpg")
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create image form field: %w", err)
+	}
+	if _, err := imagePart.Write(buf.Bytes()); err != nil {
+		return nil, "", fmt.Errorf("could not write image to form: %w", err)
+	}
+
+	pointsJSON, err := json.Marshal(points)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not marshal points: %w", err)
+	}
+	if err := writer.WriteField("points", string(pointsJSON)); err != nil {
+		return nil, "", fmt.Errorf("could not write points to form: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", fmt.Errorf("could not close multipart writer: %w", err)
+	}
+
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+type PingResult struct {
+	URL     string `json:"url"`
+	Circuit string `json:"circuit"`
+}
+
+// Ping checks that the scanner service answers its health endpoint, it does not
+// go through the circuit breaker
+func (c *Client) Ping(ctx context.Context) (PingResult, error) {
+	result := PingResult{Circuit: c.breaker.String()}
+
+	base, err := url.Parse(c.cfg.URL)
+	if err != nil {
+		return result, err
+	}
+	healthURL := base.ResolveReference(&url.URL{Path: "/healthz"}).String()
+	result.URL = healthURL
+
+	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
+	if err != nil {
+		return result, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode != http.StatusOK {
+		return result, &StatusError{StatusCode: resp.StatusCode}
+	}
+	return result, nil
+}
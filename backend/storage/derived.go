@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/navalesnahuel/slurp-tools/types"
+)
+
+// derivedPath names the cached variant of v. Derived files, such as
+// thumbnails, sit next to the versions they were made from and their names
+// include the version's creation time because version numbers are reused
+// after an undo.
+func (s *ImageStore) derivedPath(v types.ImageVersion, variant string) string {
+	return filepath.Join(s.derivedDir, fmt.Sprintf("%s__v%d__%d__%s", v.UUID, v.Version, v.CreatedAt.UnixNano(), variant))
+}
+
+// LoadDerived returns the cached variant of v, the error satisfies
+// os.IsNotExist when there is none yet
+func (s *ImageStore) LoadDerived(v types.ImageVersion, variant string) ([]byte, error) {
+	return os.ReadFile(s.derivedPath(v, variant))
+}
+
+// SaveDerived caches data as the variant of v. Nothing is cached once v is
+// no longer part of the image's history, its files have already been dropped.
+func (s *ImageStore) SaveDerived(v types.ImageVersion, variant string, data []byte) error {
+	path := s.derivedPath(v, variant)
+	file, err := os.CreateTemp(s.derivedDir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// dropDerived runs under the write lock, so v cannot be dropped between
+	// the check and the rename
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.hasVersion(v) {
+		return nil
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s *ImageStore) hasVersion(v types.ImageVersion) bool {
+	versions := s.images[v.UUID]
+	return v.Version < len(versions) && versions[v.Version].CreatedAt.Equal(v.CreatedAt)
+}
+
+// dropDerived removes every cached variant of the given versions
+func (s *ImageStore) dropDerived(versions []types.ImageVersion) {
+	for _, v := range versions {
+		matches, _ := filepath.Glob(filepath.Join(s.derivedDir, fmt.Sprintf("%s__v%d__*", v.UUID, v.Version)))
+		for _, m := range matches {
+			os.Remove(m)
+		}
+	}
+}
@@ -1,64 +1,129 @@
 package storage
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/navalesnahuel/slurp-tools/tracing"
 	"github.com/navalesnahuel/slurp-tools/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ImageStore struct {
-	tempDir string
-	images  map[string][]types.ImageVersion
-	current map[string]int
+	mu         sync.RWMutex
+	tempDir    string
+	derivedDir string
+	images     map[string][]types.ImageVersion
+	recipes    map[string][]types.Recipe // parallel to images
+	current    map[string]int
+	// generations changes when an image's history is replaced, it is kept
+	// after the image is gone so stale saves cannot recreate it
+	generations    map[string]uint64
+	lastGeneration uint64
 }
 
 func NewImageStore() *ImageStore {
 	tempDir := "./tmp/images/"
-	err := os.MkdirAll(tempDir, os.ModePerm)
-	if err != nil {
-		log.Fatal(err)
+	derivedDir := "./tmp/derived/"
+	for _, dir := range []string{tempDir, derivedDir} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	return &ImageStore{
-		tempDir: tempDir,
-		images:  make(map[string][]types.ImageVersion),
-		current: make(map[string]int),
+		tempDir:     tempDir,
+		derivedDir:  derivedDir,
+		images:      make(map[string][]types.ImageVersion),
+		recipes:     make(map[string][]types.Recipe),
+		current:     make(map[string]int),
+		generations: make(map[string]uint64),
 	}
 }
 
+// CheckWritable verifies that images can still be written to disk
+func (s *ImageStore) CheckWritable() error {
+	return checkWritable(s.tempDir)
+}
+
 func (s *ImageStore) SaveImage(img image.Image, fileName string) (string, error) {
-	ext := filepath.Ext(fileName)
+	return s.saveImage(context.Background(), img, fileName)
+}
 
-	fullPath := filepath.Join(s.tempDir, fileName)
-	file, err := os.Create(fullPath)
+// contextWriter fails the encoder's writes once ctx is canceled
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+// saveImage encodes img to a temporary file and moves it to fileName once it
+// is complete, so a failed or canceled encode never leaves a partial image
+func (s *ImageStore) saveImage(ctx context.Context, img image.Image, fileName string) (string, error) {
+	tmp, err := s.encodeTemp(ctx, img, fileName)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	return s.moveIntoPlace(tmp, fileName)
+}
 
-	switch strings.ToLower(ext) {
-	case ".png":
-		err = png.Encode(file, img)
-	case ".jpg", ".jpeg", "":
-		err = jpeg.Encode(file, img, nil)
-	default:
+// encodeTemp encodes img to a temporary file next to where fileName will go,
+// the caller moves it into place or removes it
+func (s *ImageStore) encodeTemp(ctx context.Context, img image.Image, fileName string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != "" {
 		return "", fmt.Errorf("unsupported image format: %s", ext)
 	}
 
+	file, err := os.CreateTemp(s.tempDir, fileName+".*.tmp")
 	if err != nil {
 		return "", err
 	}
 
+	w := contextWriter{ctx: ctx, w: file}
+	if ext == ".png" {
+		err = png.Encode(w, img)
+	} else {
+		err = jpeg.Encode(w, img, nil)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
 	return file.Name(), nil
 }
 
+func (s *ImageStore) moveIntoPlace(tmp, fileName string) (string, error) {
+	fullPath := filepath.Join(s.tempDir, fileName)
+	if err := os.Rename(tmp, fullPath); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return fullPath, nil
+}
+
 func (s *ImageStore) LoadImage(path string) (image.Image, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -77,42 +142,177 @@ func (s *ImageStore) DeleteImages(path string) error {
 	return os.Remove(path)
 }
 
-func (s *ImageStore) SaveVersion(uuid string, img image.Image) (types.ImageVersion, error) {
+// SaveVersion stores img as the newest version of uuid, the version is the
+// base of its own recipe. generation is the Generation of the version img was
+// made from, or zero for a new image; ErrStale is returned when the history
+// was replaced since. Nothing is recorded when ctx is canceled before the
+// image is fully written.
+func (s *ImageStore) SaveVersion(ctx context.Context, uuid string, generation uint64, img image.Image) (types.ImageVersion, error) {
+	return s.saveVersion(ctx, uuid, generation, img, nil)
+}
+
+// SaveRecipeVersion stores img as the newest version of uuid, rendered by recipe
+func (s *ImageStore) SaveRecipeVersion(ctx context.Context, uuid string, generation uint64, img image.Image, recipe types.Recipe) (types.ImageVersion, error) {
+	return s.saveVersion(ctx, uuid, generation, img, &recipe)
+}
+
+func (s *ImageStore) saveVersion(ctx context.Context, uuid string, generation uint64, img image.Image, recipe *types.Recipe) (_ types.ImageVersion, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "ImageStore.SaveVersion", trace.WithAttributes(attribute.String("image.uuid", uuid)))
+	defer func() { tracing.End(span, err) }()
+
+	// encoding takes a while, only the move into place happens under the
+	// lock so other saves and readers are not held up
+	tmp, err := s.encodeTemp(ctx, img, generateFilename(uuid, 0))
+	if err != nil {
+		return types.ImageVersion{}, err
+	}
+	defer os.Remove(tmp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return types.ImageVersion{}, err
+	}
+
 	versions := s.images[uuid]
+	recipes := s.recipes[uuid]
 	currentIdx := s.current[uuid]
 
-	if currentIdx < len(versions)-1 {
-		versions = versions[:currentIdx+1]
-		s.images[uuid] = versions
+	// work that started before the history was replaced, by a redaction for
+	// instance, must not bring back the old pixels
+	if generation != s.generations[uuid] {
+		return types.ImageVersion{}, fmt.Errorf("%w: the history of %s was replaced", ErrStale, uuid)
+	}
+	if recipe != nil && (recipe.Base > currentIdx || recipe.Base >= len(recipes) || recipes[recipe.Base].Origin != recipe.Origin) {
+		return types.ImageVersion{}, fmt.Errorf("%w: base version %d of %s was replaced", ErrStale, recipe.Base, uuid)
 	}
 
-	newVersion := len(versions)
-	filename := generateFilename(uuid, newVersion)
-	path, err := s.SaveImage(img, filename)
+	newVersion := min(currentIdx+1, len(versions))
+	path, err := s.moveIntoPlace(tmp, generateFilename(uuid, newVersion))
 	if err != nil {
 		return types.ImageVersion{}, err
 	}
 
+	if newVersion < len(versions) {
+		s.dropDerived(versions[newVersion:])
+		versions = versions[:newVersion]
+		recipes = recipes[:newVersion]
+	}
+
 	v := types.ImageVersion{
-		UUID:     uuid,
-		Version:  newVersion,
-		FilePath: path,
+		UUID:       uuid,
+		Version:    newVersion,
+		FilePath:   path,
+		CreatedAt:  time.Now(),
+		Generation: generation,
+	}
+	span.SetAttributes(attribute.Int("image.version", newVersion))
+
+	if recipe == nil {
+		recipe = &types.Recipe{Base: newVersion, Steps: []types.RecipeStep{}, Origin: newOrigin()}
 	}
 
-	s.images[uuid] = append(s.images[uuid], v)
+	s.images[uuid] = append(versions, v)
+	s.recipes[uuid] = append(recipes, *recipe)
 	s.current[uuid] = newVersion
 	return v, nil
 }
 
+// ReplaceHistory stores img as the only version of uuid and deletes every
+// other version along with the files derived from them, so no earlier pixels
+// can be restored through undo, redo or a recipe. It starts a new generation:
+// saves of images made from the old history fail with ErrStale, and so does
+// this call when generation is no longer current. It returns the new version
+// and how many versions were removed.
+func (s *ImageStore) ReplaceHistory(ctx context.Context, uuid string, generation uint64, img image.Image) (_ types.ImageVersion, removed int, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "ImageStore.ReplaceHistory", trace.WithAttributes(attribute.String("image.uuid", uuid)))
+	defer func() { tracing.End(span, err) }()
+
+	tmp, err := s.encodeTemp(ctx, img, generateFilename(uuid, 0))
+	if err != nil {
+		return types.ImageVersion{}, 0, err
+	}
+	defer os.Remove(tmp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.images[uuid]
+	if len(versions) == 0 {
+		return types.ImageVersion{}, 0, fmt.Errorf("%w: no versions for uuid %s", ErrNotFound, uuid)
+	}
+	if generation != s.generations[uuid] {
+		return types.ImageVersion{}, 0, fmt.Errorf("%w: the history of %s was replaced", ErrStale, uuid)
+	}
+
+	// version 0 is overwritten by the rename, the others are removed once
+	// the new file is in place
+	path, err := s.moveIntoPlace(tmp, generateFilename(uuid, 0))
+	if err != nil {
+		return types.ImageVersion{}, 0, err
+	}
+	s.dropDerived(versions)
+	for _, v := range versions[1:] {
+		if err := os.Remove(v.FilePath); err != nil && !os.IsNotExist(err) {
+			return types.ImageVersion{}, 0, err
+		}
+	}
+
+	s.lastGeneration++
+	s.generations[uuid] = s.lastGeneration
+
+	v := types.ImageVersion{UUID: uuid, Version: 0, FilePath: path, CreatedAt: time.Now(), Generation: s.lastGeneration}
+	s.images[uuid] = []types.ImageVersion{v}
+	s.recipes[uuid] = []types.Recipe{{Base: 0, Steps: []types.RecipeStep{}, Origin: newOrigin()}}
+	s.current[uuid] = 0
+	return v, len(versions) - 1, nil
+}
+
+// Stats returns how many images are tracked and how many versions they hold
+func (s *ImageStore) Stats() (images, versions int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.images {
+		if len(v) > 0 {
+			images++
+			versions += len(v)
+		}
+	}
+	return images, versions
+}
+
 func (s *ImageStore) LoadLatest(uuid string) (image.Image, error) {
+	s.mu.RLock()
 	idx, ok := s.current[uuid]
 	if !ok || len(s.images[uuid]) == 0 {
-		return nil, fmt.Errorf("no versions found for uuid %s", uuid)
+		s.mu.RUnlock()
+		return nil, fmt.Errorf("%w: no versions for uuid %s", ErrNotFound, uuid)
 	}
-	return s.LoadImage(s.images[uuid][idx].FilePath)
+	path := s.images[uuid][idx].FilePath
+	s.mu.RUnlock()
+
+	return s.LoadImage(path)
+}
+
+func (s *ImageStore) LoadVersion(uuid string, version int) (image.Image, error) {
+	s.mu.RLock()
+	versions := s.images[uuid]
+	if version < 0 || version >= len(versions) {
+		s.mu.RUnlock()
+		return nil, fmt.Errorf("%w: no version %d for uuid %s", ErrNotFound, version, uuid)
+	}
+	path := versions[version].FilePath
+	s.mu.RUnlock()
+
+	return s.LoadImage(path)
 }
 
 func (s *ImageStore) UndoChange(uuid string) (types.ImageVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	current, ok := s.current[uuid]
 	if !ok || current <= 0 {
 		return types.ImageVersion{}, fmt.Errorf("nothing to undo for %s", uuid)
@@ -123,6 +323,9 @@ func (s *ImageStore) UndoChange(uuid string) (types.ImageVersion, error) {
 }
 
 func (s *ImageStore) RedoChange(uuid string) (types.ImageVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	current, ok := s.current[uuid]
 	versions := s.images[uuid]
 
@@ -134,6 +337,100 @@ func (s *ImageStore) RedoChange(uuid string) (types.ImageVersion, error) {
 	return versions[s.current[uuid]], nil
 }
 
+// CanRedo reports whether uuid has undone versions, which the next saved
+// version discards
+func (s *ImageStore) CanRedo(uuid string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	current, ok := s.current[uuid]
+	return ok && current < len(s.images[uuid])-1
+}
+
+// DiscardVersion removes version when it is still the newest one of uuid,
+// undoing a SaveVersion without leaving it in the redo history
+func (s *ImageStore) DiscardVersion(uuid string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.images[uuid]
+	if version != len(versions)-1 || s.current[uuid] != version {
+		return fmt.Errorf("version %d of %s is no longer the latest", version, uuid)
+	}
+
+	path := versions[version].FilePath
+	s.dropDerived(versions[version:])
+	s.images[uuid] = versions[:version]
+	s.recipes[uuid] = s.recipes[uuid][:version]
+	if version == 0 {
+		delete(s.images, uuid)
+		delete(s.recipes, uuid)
+		delete(s.current, uuid)
+	} else {
+		s.current[uuid] = version - 1
+	}
+	return os.Remove(path)
+}
+
+// LoadCurrent returns the raster of the version uuid currently points at
+// together with the version, whose Generation is passed back when saving
+// what was made from the raster
+func (s *ImageStore) LoadCurrent(uuid string) (image.Image, types.ImageVersion, error) {
+	// the version is read first, if the file is replaced afterwards the save
+	// is refused rather than the other way around
+	v, err := s.Current(uuid)
+	if err != nil {
+		return nil, types.ImageVersion{}, err
+	}
+	img, err := s.LoadImage(v.FilePath)
+	if err != nil {
+		return nil, types.ImageVersion{}, err
+	}
+	return img, v, nil
+}
+
+// Current returns the version uuid currently points at
+func (s *ImageStore) Current(uuid string) (types.ImageVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, ok := s.current[uuid]
+	if !ok || len(s.images[uuid]) == 0 {
+		return types.ImageVersion{}, fmt.Errorf("%w: no versions for uuid %s", ErrNotFound, uuid)
+	}
+	return s.images[uuid][idx], nil
+}
+
+// CurrentRecipe returns the recipe of the version uuid currently points at
+func (s *ImageStore) CurrentRecipe(uuid string) (types.ImageVersion, types.Recipe, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, ok := s.current[uuid]
+	if !ok || len(s.images[uuid]) == 0 {
+		return types.ImageVersion{}, types.Recipe{}, fmt.Errorf("%w: no versions for uuid %s", ErrNotFound, uuid)
+	}
+	return s.images[uuid][idx], s.recipes[uuid][idx].Clone(), nil
+}
+
+// Recipe returns the recipe that rendered v, or ErrStale once v is no longer
+// part of the history
+func (s *ImageStore) Recipe(v types.ImageVersion) (types.Recipe, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.hasVersion(v) {
+		return types.Recipe{}, fmt.Errorf("%w: version %d of %s was replaced", ErrStale, v.Version, v.UUID)
+	}
+	return s.recipes[v.UUID][v.Version].Clone(), nil
+}
+
+func newOrigin() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
 func generateFilename(uuid string, version int) string {
 	return fmt.Sprintf("%s__v%d.jpg", uuid, version)
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
@@ -23,6 +24,11 @@ func NewMemStore() *MemStore {
 	return &MemStore{tempDir: tempDir}
 }
 
+// CheckWritable verifies that files can still be written to disk
+func (m *MemStore) CheckWritable() error {
+	return checkWritable(m.tempDir)
+}
+
 func (m *MemStore) Save(name string, data io.Reader) (string, error) {
 	ext := filepath.Ext(name)
 	uniqueID := uuid.NewString()
@@ -53,3 +59,38 @@ func (m *MemStore) Load(path string) (*os.File, error) {
 	}
 	return f, nil
 }
+
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write([]byte("ok")); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// DirSize adds up the size of the regular files under dir
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// removed while walking
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
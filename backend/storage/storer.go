@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"image"
 	"io"
 	"os"
@@ -8,6 +10,14 @@ import (
 	"github.com/navalesnahuel/slurp-tools/types"
 )
 
+var (
+	// ErrNotFound is returned for unknown images and versions
+	ErrNotFound = errors.New("image not found")
+	// ErrStale is returned when a render is saved on top of a history that
+	// was replaced while it ran
+	ErrStale = errors.New("image changed")
+)
+
 type Storer interface {
 	Save(string, io.Reader) (string, error)
 	Delete(string) error
@@ -18,8 +28,19 @@ type ImageStorer interface {
 	LoadImage(string) (image.Image, error)
 	SaveImage(image.Image, string) (string, error)
 	DeleteImages(string) error
-	SaveVersion(string, image.Image) (types.ImageVersion, error)
+	SaveVersion(context.Context, string, uint64, image.Image) (types.ImageVersion, error)
+	SaveRecipeVersion(context.Context, string, uint64, image.Image, types.Recipe) (types.ImageVersion, error)
+	Current(string) (types.ImageVersion, error)
+	CurrentRecipe(string) (types.ImageVersion, types.Recipe, error)
+	Recipe(types.ImageVersion) (types.Recipe, error)
 	LoadLatest(string) (image.Image, error)
+	LoadCurrent(string) (image.Image, types.ImageVersion, error)
+	LoadVersion(string, int) (image.Image, error)
 	UndoChange(string) (types.ImageVersion, error)
 	RedoChange(string) (types.ImageVersion, error)
+	CanRedo(string) bool
+	DiscardVersion(string, int) error
+	ReplaceHistory(context.Context, string, uint64, image.Image) (types.ImageVersion, int, error)
+	LoadDerived(types.ImageVersion, string) ([]byte, error)
+	SaveDerived(types.ImageVersion, string, []byte) error
 }
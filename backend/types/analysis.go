@@ -0,0 +1,177 @@
+package types
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Blank page detection thresholds
+const (
+	// pixels darker than this (after Otsu) count as ink
+	MaxInkThreshold = 128
+	// fraction of the page covered by ink
+	BlankMaxInkCoverage = 0.01
+	// variance of the per tile ink coverage, speckle noise is spread evenly
+	// while real content is concentrated in a few tiles
+	BlankMaxInkVariance = 0.00005
+	// border ignored on each side, scanner edges are usually dark
+	blankMarginRatio = 0.05
+	blankTiles       = 16
+
+	// bilevel detection, share of pixels allowed between dark and light
+	bilevelDark        = 40
+	bilevelLight       = 215
+	bilevelMaxMidtones = 0.01
+)
+
+type PageAnalysis struct {
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	Threshold   uint8   `json:"threshold"`
+	InkCoverage float64 `json:"ink_coverage"`
+	InkVariance float64 `json:"ink_variance"`
+	Blank       bool    `json:"blank"`
+}
+
+// AnalyzePage binarizes the image and reports whether it looks like a blank page
+func AnalyzePage(img image.Image) PageAnalysis {
+	bounds := img.Bounds()
+	analysis := PageAnalysis{Width: bounds.Dx(), Height: bounds.Dy(), Blank: true}
+
+	marginX := int(float64(bounds.Dx()) * blankMarginRatio)
+	marginY := int(float64(bounds.Dy()) * blankMarginRatio)
+	inner := image.Rect(
+		bounds.Min.X+marginX, bounds.Min.Y+marginY,
+		bounds.Max.X-marginX, bounds.Max.Y-marginY,
+	)
+	if inner.Empty() {
+		return analysis
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, inner.Dx(), inner.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, inner.Min, draw.Src)
+
+	var histogram [256]int
+	for _, p := range gray.Pix {
+		histogram[p]++
+	}
+
+	threshold := otsuThreshold(histogram, len(gray.Pix))
+	if threshold > MaxInkThreshold {
+		threshold = MaxInkThreshold
+	}
+	analysis.Threshold = threshold
+
+	w, h := gray.Bounds().Dx(), gray.Bounds().Dy()
+	tilesX, tilesY := min(blankTiles, w), min(blankTiles, h)
+	inkPerTile := make([]int, tilesX*tilesY)
+	pixelsPerTile := make([]int, tilesX*tilesY)
+
+	ink := 0
+	for y := 0; y < h; y++ {
+		ty := y * tilesY / h
+		row := gray.Pix[y*gray.Stride : y*gray.Stride+w]
+		for x, p := range row {
+			tile := ty*tilesX + x*tilesX/w
+			pixelsPerTile[tile]++
+			if p < threshold {
+				inkPerTile[tile]++
+				ink++
+			}
+		}
+	}
+
+	analysis.InkCoverage = float64(ink) / float64(w*h)
+
+	var variance float64
+	for i := range inkPerTile {
+		d := float64(inkPerTile[i])/float64(pixelsPerTile[i]) - analysis.InkCoverage
+		variance += d * d
+	}
+	analysis.InkVariance = variance / float64(len(inkPerTile))
+
+	analysis.Blank = analysis.InkCoverage <= BlankMaxInkCoverage && analysis.InkVariance <= BlankMaxInkVariance
+	return analysis
+}
+
+// IsBilevel reports whether the image is (almost) only black and white pixels,
+// as produced by the scan pipeline
+func IsBilevel(img image.Image) bool {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+
+	midtones := 0
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		r, g, b := int(rgba.Pix[i]), int(rgba.Pix[i+1]), int(rgba.Pix[i+2])
+		if abs(r-g) > 16 || abs(g-b) > 16 || (g > bilevelDark && g < bilevelLight) {
+			midtones++
+		}
+	}
+	return float64(midtones) <= bilevelMaxMidtones*float64(bounds.Dx()*bounds.Dy())
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Binarize converts the image to 1-bit black and white using Otsu's threshold
+func Binarize(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	draw.Draw(gray, bounds, img, bounds.Min, draw.Src)
+
+	var histogram [256]int
+	for _, p := range gray.Pix {
+		histogram[p]++
+	}
+	threshold := otsuThreshold(histogram, len(gray.Pix))
+
+	bw := image.NewPaletted(bounds, color.Palette{color.Black, color.White})
+	for y := 0; y < bounds.Dy(); y++ {
+		row := gray.Pix[y*gray.Stride : y*gray.Stride+bounds.Dx()]
+		for x, p := range row {
+			if p >= threshold {
+				bw.Pix[y*bw.Stride+x] = 1
+			}
+		}
+	}
+	return bw
+}
+
+func otsuThreshold(histogram [256]int, total int) uint8 {
+	var sum float64
+	for i, c := range histogram {
+		sum += float64(i * c)
+	}
+
+	var sumB, best float64
+	var weightB int
+	var threshold uint8
+	for i, c := range histogram {
+		weightB += c
+		if weightB == 0 {
+			continue
+		}
+		weightF := total - weightB
+		if weightF == 0 {
+			break
+		}
+
+		sumB += float64(i * c)
+		meanB := sumB / float64(weightB)
+		meanF := (sum - sumB) / float64(weightF)
+		between := float64(weightB) * float64(weightF) * (meanB - meanF) * (meanB - meanF)
+		if between > best {
+			best = between
+			threshold = uint8(i)
+		}
+	}
+
+	// pixels strictly below the threshold are ink
+	return threshold + 1
+}
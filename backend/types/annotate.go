@@ -0,0 +1,443 @@
+package types
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/gift"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// Annotate draws one shape or text label on top of the image. Every
+// annotation is its own filter, so each one becomes a recipe step that can be
+// edited, moved or removed on its own.
+//
+// Points are in image pixels: rectangles and ellipses take two opposite
+// corners, arrows their tail and tip, polylines and highlights every vertex
+// and text the top left corner of the label.
+type Annotate struct {
+	Shape   string       `json:"shape"` // rectangle, ellipse, arrow, polyline, highlight or text
+	Points  [][2]float64 `json:"points"`
+	Color   string       `json:"color"`   // #rgb, #rrggbb or #rrggbbaa
+	Width   float64      `json:"width"`   // stroke width
+	Fill    bool         `json:"fill"`    // rectangles and ellipses only
+	Opacity float64      `json:"opacity"` // 0 to 1, zero means the shape's default
+	Text    string       `json:"text"`
+	Size    float64      `json:"size"` // font size in pixels
+	Font    string       `json:"font"` // regular, bold or mono
+}
+
+const (
+	defaultStrokeWidth    = 3
+	defaultHighlightWidth = 16
+	defaultFontSize       = 24
+	highlightOpacity      = 0.4
+	maxFontSize           = 4096
+
+	// points may lie outside the image, but no further than a few times the
+	// largest image size, which keeps them well within the rasterizer's range
+	maxCoordinate = 4 * 65536
+)
+
+var annotationPoints = map[string]struct{ min, max int }{
+	"rectangle": {2, 2},
+	"ellipse":   {2, 2},
+	"arrow":     {2, 2},
+	"polyline":  {2, math.MaxInt},
+	"highlight": {2, math.MaxInt},
+	"text":      {1, 1},
+}
+
+var fonts = map[string]*opentype.Font{
+	"regular": mustParseFont(goregular.TTF),
+	"bold":    mustParseFont(gobold.TTF),
+	"mono":    mustParseFont(gomono.TTF),
+}
+
+func (f Annotate) Validate() error {
+	n, ok := annotationPoints[f.Shape]
+	if !ok {
+		return paramError("shape", "must be rectangle, ellipse, arrow, polyline, highlight or text")
+	}
+	if len(f.Points) < n.min || len(f.Points) > n.max {
+		if n.min == n.max {
+			return paramError("points", "must have exactly %d points for shape %s", n.min, f.Shape)
+		}
+		return paramError("points", "must have at least %d points for shape %s", n.min, f.Shape)
+	}
+	for _, p := range f.Points {
+		if !validPoint(p) {
+			return paramError("points", "coordinates must be finite and between -%d and %d", maxCoordinate, maxCoordinate)
+		}
+	}
+	if f.Color != "" {
+		if _, err := parseHexColor(f.Color); err != nil {
+			return paramError("color", "must be #rgb, #rrggbb or #rrggbbaa")
+		}
+	}
+	// written so that NaN fails the range checks too
+	if !(f.Width >= 0 && f.Width <= maxCoordinate) {
+		return paramError("width", "must be between 0 and %d", maxCoordinate)
+	}
+	if !(f.Opacity >= 0 && f.Opacity <= 1) {
+		return paramError("opacity", "must be between 0 and 1")
+	}
+
+	if f.Shape == "text" {
+		if strings.TrimSpace(f.Text) == "" {
+			return paramError("text", "must not be empty")
+		}
+		if !(f.Size >= 0 && f.Size <= maxFontSize) {
+			return paramError("size", "must be between 0 and %d", maxFontSize)
+		}
+		if _, ok := fonts[f.Font]; !ok && f.Font != "" {
+			return paramError("font", "must be regular, bold or mono")
+		}
+	}
+	return nil
+}
+
+func (f Annotate) ToGift() gift.Filter {
+	return annotateFilter{f}
+}
+
+func (f Annotate) strokeWidth() float64 {
+	switch {
+	case f.Width > 0:
+		return f.Width
+	case f.Shape == "highlight":
+		return defaultHighlightWidth
+	}
+	return defaultStrokeWidth
+}
+
+func (f Annotate) fontSize() float64 {
+	if f.Size > 0 {
+		return f.Size
+	}
+	return defaultFontSize
+}
+
+// paint is the annotation's color with its opacity applied
+func (f Annotate) paint() color.Color {
+	c := color.NRGBA{255, 0, 0, 255}
+	switch {
+	case f.Color != "":
+		c, _ = parseHexColor(f.Color)
+	case f.Shape == "highlight":
+		c = color.NRGBA{255, 235, 0, 255}
+	case f.Shape == "text":
+		c = color.NRGBA{0, 0, 0, 255}
+	}
+
+	opacity := f.Opacity
+	if opacity == 0 {
+		opacity = 1
+		if f.Shape == "highlight" {
+			opacity = highlightOpacity
+		}
+	}
+	c.A = uint8(math.Round(float64(c.A) * opacity))
+	return c
+}
+
+type annotateFilter struct {
+	Annotate
+}
+
+func (f annotateFilter) Bounds(srcBounds image.Rectangle) image.Rectangle {
+	return srcBounds
+}
+
+func (f annotateFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+	bounds := dst.Bounds()
+	draw.Draw(dst, bounds, src, src.Bounds().Min, draw.Src)
+
+	origin := [2]float64{float64(bounds.Min.X), float64(bounds.Min.Y)}
+	points := make([][2]float64, len(f.Points))
+	for i, p := range f.Points {
+		points[i] = [2]float64{p[0] + origin[0], p[1] + origin[1]}
+	}
+
+	paint := image.NewUniform(f.paint())
+	if f.Shape == "text" {
+		f.drawText(dst, points[0], paint)
+		return
+	}
+
+	var polys [][][2]float64
+	width := f.strokeWidth()
+	switch f.Shape {
+	case "rectangle":
+		a, b := points[0], points[1]
+		outline := [][2]float64{a, {b[0], a[1]}, b, {a[0], b[1]}}
+		if f.Fill {
+			polys = append(polys, outline)
+		} else {
+			polys = strokePolygons(append(outline, a), width)
+		}
+	case "ellipse":
+		outline := ellipsePolygon(points[0], points[1])
+		if f.Fill {
+			polys = append(polys, outline)
+		} else {
+			polys = strokePolygons(append(outline, outline[0]), width)
+		}
+	case "arrow":
+		polys = arrowPolygons(points[0], points[1], width)
+	case "polyline", "highlight":
+		polys = strokePolygons(points, width)
+	}
+	fillPolygons(dst, polys, paint)
+}
+
+func (f Annotate) drawText(dst draw.Image, at [2]float64, paint image.Image) {
+	name := f.Font
+	if name == "" {
+		name = "regular"
+	}
+	face, err := opentype.NewFace(fonts[name], &opentype.FaceOptions{
+		Size:    f.fontSize(),
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return
+	}
+	defer face.Close()
+
+	d := font.Drawer{Dst: dst, Src: paint, Face: face}
+	metrics := face.Metrics()
+	for i, line := range strings.Split(f.Text, "\n") {
+		d.Dot = fixed.Point26_6{
+			X: fixed.Int26_6(at[0] * 64),
+			Y: fixed.Int26_6(at[1]*64) + metrics.Ascent + fixed.Int26_6(i)*metrics.Height,
+		}
+		d.DrawString(line)
+	}
+}
+
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// fillPolygons paints the union of polys. The rasterizer adds up signed
+// areas, so every polygon is wound the same way to keep overlaps from
+// cancelling out, and a translucent color is applied only once per pixel.
+// Polygons are clipped to the painted area first, the rasterizer misbehaves
+// on coordinates far outside of it.
+func fillPolygons(dst draw.Image, polys [][][2]float64, paint image.Image) {
+	if len(polys) == 0 {
+		return
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, poly := range polys {
+		for _, p := range poly {
+			minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+			minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+		}
+	}
+	area := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1).
+		Intersect(dst.Bounds())
+	if area.Empty() {
+		return
+	}
+
+	var z vector.Rasterizer
+	z.Reset(area.Dx(), area.Dy())
+	for _, poly := range polys {
+		if signedArea(poly) < 0 {
+			poly = reversed(poly)
+		}
+		poly = clipPolygon(poly, area)
+		if len(poly) == 0 {
+			continue
+		}
+		for i, p := range poly {
+			x, y := float32(p[0]-float64(area.Min.X)), float32(p[1]-float64(area.Min.Y))
+			if i == 0 {
+				z.MoveTo(x, y)
+			} else {
+				z.LineTo(x, y)
+			}
+		}
+		z.ClosePath()
+	}
+	z.Draw(dst, area, paint, image.Point{})
+}
+
+// strokePolygons outlines a path of the given width as one quad per segment
+// and a disc at every vertex for round joins and caps
+func strokePolygons(points [][2]float64, width float64) [][][2]float64 {
+	r := width / 2
+	polys := make([][][2]float64, 0, 2*len(points))
+	for i, p := range points {
+		polys = append(polys, circlePolygon(p, r))
+		if i == 0 {
+			continue
+		}
+		q := points[i-1]
+		dx, dy := p[0]-q[0], p[1]-q[1]
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*r, dx/length*r
+		polys = append(polys, [][2]float64{
+			{q[0] + nx, q[1] + ny},
+			{p[0] + nx, p[1] + ny},
+			{p[0] - nx, p[1] - ny},
+			{q[0] - nx, q[1] - ny},
+		})
+	}
+	return polys
+}
+
+// arrowPolygons draws a shaft from tail to tip ending in a filled head
+func arrowPolygons(tail, tip [2]float64, width float64) [][][2]float64 {
+	dx, dy := tip[0]-tail[0], tip[1]-tail[1]
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return nil
+	}
+	ux, uy := dx/length, dy/length
+
+	head := math.Min(math.Max(4*width, 12), length)
+	base := [2]float64{tip[0] - ux*head, tip[1] - uy*head}
+	half := head / 2
+	polys := [][][2]float64{{
+		tip,
+		{base[0] - uy*half, base[1] + ux*half},
+		{base[0] + uy*half, base[1] - ux*half},
+	}}
+	if head < length {
+		polys = append(polys, strokePolygons([][2]float64{tail, base}, width)...)
+	}
+	return polys
+}
+
+func ellipsePolygon(a, b [2]float64) [][2]float64 {
+	cx, cy := (a[0]+b[0])/2, (a[1]+b[1])/2
+	rx, ry := math.Abs(b[0]-a[0])/2, math.Abs(b[1]-a[1])/2
+	return arcPolygon(cx, cy, rx, ry)
+}
+
+func circlePolygon(c [2]float64, r float64) [][2]float64 {
+	return arcPolygon(c[0], c[1], r, r)
+}
+
+func arcPolygon(cx, cy, rx, ry float64) [][2]float64 {
+	// enough segments to keep the error under a quarter of a pixel
+	n := int(math.Ceil(math.Pi / math.Acos(1-0.25/math.Max(math.Max(rx, ry), 1))))
+	n = min(max(n, 8), 720)
+
+	poly := make([][2]float64, n)
+	for i := range poly {
+		t := 2 * math.Pi * float64(i) / float64(n)
+		poly[i] = [2]float64{cx + rx*math.Cos(t), cy + ry*math.Sin(t)}
+	}
+	return poly
+}
+
+// clipPolygon cuts poly down to the part inside r. The outline outside r is
+// replaced by runs along its edges, which keeps the coverage inside r as is.
+func clipPolygon(poly [][2]float64, r image.Rectangle) [][2]float64 {
+	for _, edge := range []struct {
+		axis  int
+		bound float64
+		below bool
+	}{
+		{0, float64(r.Min.X), false},
+		{0, float64(r.Max.X), true},
+		{1, float64(r.Min.Y), false},
+		{1, float64(r.Max.Y), true},
+	} {
+		inside := func(p [2]float64) bool {
+			if edge.below {
+				return p[edge.axis] <= edge.bound
+			}
+			return p[edge.axis] >= edge.bound
+		}
+
+		clipped := make([][2]float64, 0, len(poly)+2)
+		for i, p := range poly {
+			q := poly[(i+1)%len(poly)]
+			if inside(p) {
+				clipped = append(clipped, p)
+			}
+			if inside(p) != inside(q) {
+				t := (edge.bound - p[edge.axis]) / (q[edge.axis] - p[edge.axis])
+				var c [2]float64
+				c[edge.axis] = edge.bound
+				c[1-edge.axis] = p[1-edge.axis] + t*(q[1-edge.axis]-p[1-edge.axis])
+				clipped = append(clipped, c)
+			}
+		}
+		if len(clipped) == 0 {
+			return nil
+		}
+		poly = clipped
+	}
+	return poly
+}
+
+func validPoint(p [2]float64) bool {
+	// NaN fails both comparisons
+	return math.Abs(p[0]) <= maxCoordinate && math.Abs(p[1]) <= maxCoordinate
+}
+
+func signedArea(poly [][2]float64) float64 {
+	var a float64
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		a += p[0]*q[1] - q[0]*p[1]
+	}
+	return a / 2
+}
+
+func reversed(poly [][2]float64) [][2]float64 {
+	r := make([][2]float64, len(poly))
+	for i, p := range poly {
+		r[len(poly)-1-i] = p
+	}
+	return r
+}
+
+func parseHexColor(s string) (color.NRGBA, error) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
@@ -0,0 +1,75 @@
+package types
+
+import "fmt"
+
+// MaxBatchImages bounds how many images one batch request may touch
+const MaxBatchImages = 200
+
+// Batch item statuses
+const (
+	BatchOK         = "ok"
+	BatchFailed     = "failed"
+	BatchRolledBack = "rolled_back" // succeeded, then undone because another image failed
+	BatchSkipped    = "skipped"     // not processed because the batch was aborted
+)
+
+type BatchFilterRequest struct {
+	Images  []string        `json:"images"`
+	Filters []FilterRequest `json:"filters,omitempty"`
+	Preset  string          `json:"preset,omitempty"`
+	// Atomic applies the chain to every image or to none of them. It is
+	// refused while an image has undone versions: saving discards them and a
+	// rollback could not bring them back.
+	Atomic bool `json:"atomic"`
+}
+
+// Chain returns the filters to apply, resolving the preset when one is given
+func (r BatchFilterRequest) Chain() []FilterRequest {
+	if r.Preset != "" {
+		return FilterPresets[r.Preset]
+	}
+	return r.Filters
+}
+
+func (r BatchFilterRequest) Validate() error {
+	if len(r.Images) == 0 {
+		return fmt.Errorf("batch: provide at least one image")
+	}
+	if len(r.Images) > MaxBatchImages {
+		return fmt.Errorf("batch: at most %d images per request", MaxBatchImages)
+	}
+
+	seen := make(map[string]bool, len(r.Images))
+	for _, id := range r.Images {
+		if seen[id] {
+			return fmt.Errorf("batch: image %s is listed twice", id)
+		}
+		seen[id] = true
+	}
+
+	switch {
+	case r.Preset != "" && len(r.Filters) > 0:
+		return fmt.Errorf("batch: use either filters or preset, not both")
+	case r.Preset != "":
+		if _, ok := FilterPresets[r.Preset]; !ok {
+			return fmt.Errorf("batch: unknown preset %q", r.Preset)
+		}
+	case len(r.Filters) == 0:
+		return fmt.Errorf("batch: provide filters or a preset")
+	}
+	return nil
+}
+
+type BatchItemResult struct {
+	UUID    string `json:"uuid"`
+	Status  string `json:"status"`
+	Version *int   `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+type BatchFilterResult struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ParamError is a filter parameter outside of its valid range
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("%s %s", e.Param, e.Message)
+}
+
+func paramError(param, format string, args ...any) error {
+	return &ParamError{Param: param, Message: fmt.Sprintf(format, args...)}
+}
+
+// FilterError points at the invalid filter of a chain and, when known, the
+// parameter that is wrong, so clients can highlight the exact control
+type FilterError struct {
+	Index   int    `json:"index"`
+	Filter  string `json:"filter"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *FilterError) Error() string {
+	if e.Param != "" {
+		return fmt.Sprintf("filter %d (%s): %s %s", e.Index, e.Filter, e.Param, e.Message)
+	}
+	return fmt.Sprintf("filter %d (%s): %s", e.Index, e.Filter, e.Message)
+}
+
+func newFilterError(name string, err error) *FilterError {
+	fe := &FilterError{Filter: name, Message: err.Error()}
+
+	var paramErr *ParamError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &paramErr):
+		fe.Param = paramErr.Param
+		fe.Message = paramErr.Message
+	case errors.As(err, &typeErr):
+		fe.Param = typeErr.Field
+		fe.Message = "must be " + kindName(typeErr.Type.Kind())
+	case errors.As(err, new(*json.SyntaxError)):
+		// also returned when params are missing
+		fe.Message = "invalid parameters"
+	}
+	return fe
+}
+
+func kindName(k reflect.Kind) string {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	}
+	return "a valid value"
+}
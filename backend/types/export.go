@@ -0,0 +1,63 @@
+package types
+
+import "fmt"
+
+// Export containers
+const (
+	ExportPDF  = "pdf"
+	ExportTIFF = "tiff"
+	ExportZIP  = "zip"
+)
+
+// Per entry formats, tiff pages take auto, bilevel, gray or rgb and zip entries
+// take auto, png, jpeg or tiff
+const (
+	ExportFormatAuto    = "auto"
+	ExportFormatBilevel = "bilevel"
+	ExportFormatGray    = "gray"
+	ExportFormatRGB     = "rgb"
+	ExportFormatPNG     = "png"
+	ExportFormatJPEG    = "jpeg"
+	ExportFormatTIFF    = "tiff"
+)
+
+var exportEntryFormats = map[string]map[string]bool{
+	ExportPDF:  {ExportFormatAuto: true},
+	ExportTIFF: {ExportFormatAuto: true, ExportFormatBilevel: true, ExportFormatGray: true, ExportFormatRGB: true},
+	ExportZIP:  {ExportFormatAuto: true, ExportFormatPNG: true, ExportFormatJPEG: true, ExportFormatTIFF: true},
+}
+
+type ExportEntry struct {
+	ImageRef
+	Name    string `json:"name"`
+	Format  string `json:"format"`
+	Quality int    `json:"quality"` // jpeg entries, 1 to 100
+}
+
+type ExportRequest struct {
+	Container string        `json:"container"`
+	Images    []ExportEntry `json:"images"`
+	PDFOptions
+}
+
+func (r ExportRequest) Validate() error {
+	formats, ok := exportEntryFormats[r.Container]
+	if !ok {
+		return fmt.Errorf("export: container must be pdf, tiff or zip")
+	}
+
+	if len(r.Images) == 0 {
+		return fmt.Errorf("export: provide at least one image")
+	}
+
+	for i, e := range r.Images {
+		if e.Format != "" && !formats[e.Format] {
+			return fmt.Errorf("export: image %d: format %q is not supported by %s", i, e.Format, r.Container)
+		}
+		if e.Quality < 0 || e.Quality > 100 {
+			return fmt.Errorf("export: image %d: quality must be between 1 and 100", i)
+		}
+	}
+
+	return r.PDFOptions.Validate()
+}
@@ -1,8 +1,9 @@
 package types
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"image"
 	"image/color"
 	"image/draw"
@@ -25,6 +26,40 @@ func ApplyFilters(src image.Image, filters ...gift.Filter) image.Image {
 	return dst
 }
 
+// ContextFilter is implemented by filters with long running loops that can
+// stop early once the request is canceled
+type ContextFilter interface {
+	WithContext(ctx context.Context) gift.Filter
+}
+
+// ApplyFiltersContext applies the filters one at a time, checking ctx between
+// them. A canceled ctx returns its error and no image.
+func ApplyFiltersContext(ctx context.Context, src image.Image, filters ...gift.Filter) (image.Image, error) {
+	img := src
+	for _, f := range filters {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if cf, ok := f.(ContextFilter); ok {
+			f = cf.WithContext(ctx)
+		}
+		img = ApplyFilters(img, f)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// EstimateFilterMemory approximates the bytes ApplyFilters needs for an image:
+// the decoded source, the destination and gift's two intermediate RGBA buffers
+func EstimateFilterMemory(src image.Rectangle, filters ...gift.Filter) int64 {
+	dst := gift.New(filters...).Bounds(src)
+	pixels := int64(max(src.Dx()*src.Dy(), dst.Dx()*dst.Dy()))
+	return pixels * 4 * 4
+}
+
 func CreateFilter(fr FilterRequest) (Filter, error) {
 	var f Filter
 	var err error
@@ -106,6 +141,10 @@ func CreateFilter(fr FilterRequest) (Filter, error) {
 		var v ColorBalance
 		err = json.Unmarshal(fr.Params, &v)
 		f = v
+	case "annotate":
+		var v Annotate
+		err = json.Unmarshal(fr.Params, &v)
+		f = v
 	case "grayscale":
 		f = Grayscale{}
 	case "invert":
@@ -115,25 +154,47 @@ func CreateFilter(fr FilterRequest) (Filter, error) {
 	case "scanify":
 		f = NewScanifyFilter()
 	default:
-		return nil, fmt.Errorf("unknown filter: %s", fr.Filter)
+		return nil, &FilterError{Filter: fr.Filter, Message: "unknown filter"}
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, newFilterError(fr.Filter, err)
 	}
 
 	if v, ok := f.(Validater); ok {
 		if err := v.Validate(); err != nil {
-			return nil, fmt.Errorf("invalid filter %s: %w", fr.Filter, err)
+			return nil, newFilterError(fr.Filter, err)
 		}
 	}
 
 	return f, nil
 }
 
+// CreateFilters builds a whole chain, a *FilterError carries the index of the
+// first invalid filter
+func CreateFilters(frs []FilterRequest) ([]Filter, error) {
+	filters := make([]Filter, 0, len(frs))
+	for i, fr := range frs {
+		f, err := CreateFilter(fr)
+		if err != nil {
+			var filterErr *FilterError
+			if errors.As(err, &filterErr) {
+				filterErr.Index = i
+			}
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
 func (f *ScanifyFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
 	bounds := src.Bounds()
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		// the caller discards the output once ctx is canceled
+		if f.ctx != nil && f.ctx.Err() != nil {
+			return
+		}
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
 			gray := color.GrayModel.Convert(src.At(x, y)).(color.Gray)
 			var out color.Gray
@@ -159,6 +220,10 @@ func NewScanifyFilter() *ScanifyFilter {
 	return &ScanifyFilter{}
 }
 
+func (f *ScanifyFilter) WithContext(ctx context.Context) gift.Filter {
+	return &ScanifyFilter{ctx: ctx}
+}
+
 type FilterRequest struct {
 	Filter string          `json:"filter"`
 	Params json.RawMessage `json:"params"`
@@ -383,23 +448,36 @@ type ColorBalance struct {
 
 // Filters without parameters
 type (
-	Grayscale     struct{}
-	Invert        struct{}
-	Rotate180     struct{}
-	ScanifyFilter struct{}
+	Grayscale struct{}
+	Invert    struct{}
+	Rotate180 struct{}
 )
 
+// ScanifyFilter pushes light pixels to white and dark ones to black
+type ScanifyFilter struct {
+	ctx context.Context
+}
+
 // Validate method
 func (f Resize) Validate() error {
-	if f.Width <= 0 || f.Height <= 0 {
-		return fmt.Errorf("resize: width and height must be greater than zero")
+	if f.Width <= 0 {
+		return paramError("width", "must be greater than zero")
+	}
+	if f.Height <= 0 {
+		return paramError("height", "must be greater than zero")
 	}
 	return nil
 }
 
 func (f Crop) Validate() error {
-	if f.Width < 0 || f.Height < 0 || f.X < 0 || f.Y < 0 {
-		return fmt.Errorf("crop: values must be greater than zero")
+	params := []struct {
+		name  string
+		value int
+	}{{"x", f.X}, {"y", f.Y}, {"width", f.Width}, {"height", f.Height}}
+	for _, p := range params {
+		if p.value < 0 {
+			return paramError(p.name, "must not be negative")
+		}
 	}
 	return nil
 }
@@ -407,125 +485,118 @@ func (f Crop) Validate() error {
 func (f Rotate) Validate() error {
 	valid := map[string]bool{"nearest": true, "linear": true, "cubic": true}
 	if _, ok := valid[f.Interpolation]; !ok {
-		return fmt.Errorf("rotate: invalid interpolation value")
+		return paramError("interpolation", "must be nearest, linear or cubic")
 	}
 	return nil
 }
 
 func (f Brightness) Validate() error {
-	if f.Percentage < -100 || f.Percentage > 100 {
-		return fmt.Errorf("brightness: percentage must be between -100 and 100")
-	}
-	return nil
+	return inRange("percentage", f.Percentage, -100, 100)
 }
 
 func (f Contrast) Validate() error {
-	if f.Percentage < -100 || f.Percentage > 100 {
-		return fmt.Errorf("contrast: percentage must be between -100 and 100")
-	}
-	return nil
+	return inRange("percentage", f.Percentage, -100, 100)
 }
 
 func (f Saturation) Validate() error {
-	if f.Percentage < -100 || f.Percentage > 100 {
-		return fmt.Errorf("saturation: percentage must be between -100 and 100")
-	}
-	return nil
+	return inRange("percentage", f.Percentage, -100, 100)
 }
 
 func (f Gamma) Validate() error {
 	if f.Gamma <= 0 {
-		return fmt.Errorf("gamma: must be greater than zero")
+		return paramError("gamma", "must be greater than zero")
 	}
 	return nil
 }
 
 func (f GaussianBlur) Validate() error {
 	if f.Sigma <= 0 {
-		return fmt.Errorf("gaussianblur: sigma must be greater than zero")
+		return paramError("sigma", "must be greater than zero")
 	}
 	return nil
 }
 
 func (f UnsharpMask) Validate() error {
-	if f.Sigma <= 0 || f.Amount < 0 || f.Threshold < 0 {
-		return fmt.Errorf("unsharpmask: sigma must be > 0, amount and threshold >= 0")
+	if f.Sigma <= 0 {
+		return paramError("sigma", "must be greater than zero")
+	}
+	if f.Amount < 0 {
+		return paramError("amount", "must not be negative")
+	}
+	if f.Threshold < 0 {
+		return paramError("threshold", "must not be negative")
 	}
 	return nil
 }
 
 func (f Sigmoid) Validate() error {
-	if f.Contrast <= 0 || f.Midpoint < 0 || f.Midpoint > 1 {
-		return fmt.Errorf("sigmoid: contrast must be > 0, midpoint must be between 0 and 1")
+	if f.Contrast <= 0 {
+		return paramError("contrast", "must be greater than zero")
 	}
-	return nil
+	return inRange("midpoint", f.Midpoint, 0, 1)
 }
 
 func (f Pixelate) Validate() error {
 	if f.Size <= 0 {
-		return fmt.Errorf("pixelate: size must be greater than zero")
+		return paramError("size", "must be greater than zero")
 	}
 	return nil
 }
 
 func (f Colorize) Validate() error {
-	if f.Hue < 0 || f.Hue > 360 {
-		return fmt.Errorf("colorize: hue must be between 0 and 360")
-	}
-	if f.Saturation < 0 || f.Saturation > 1 {
-		return fmt.Errorf("colorize: saturation must be between 0 and 1")
+	if err := inRange("hue", f.Hue, 0, 360); err != nil {
+		return err
 	}
-	if f.Value < 0 || f.Value > 1 {
-		return fmt.Errorf("colorize: value must be between 0 and 1")
+	if err := inRange("saturation", f.Saturation, 0, 1); err != nil {
+		return err
 	}
-	return nil
+	return inRange("value", f.Value, 0, 1)
 }
 
 func (f Sepia) Validate() error {
-	if f.Percentage < 0 || f.Percentage > 100 {
-		return fmt.Errorf("sepia: percentage must be between 0 and 100")
-	}
-	return nil
+	return inRange("percentage", f.Percentage, 0, 100)
 }
 
 func (f Mean) Validate() error {
-	if f.Radius <= 0 {
-		return fmt.Errorf("mean: radius must be greater than zero")
-	}
-	return nil
+	return positiveRadius(f.Radius)
 }
 
 func (f Median) Validate() error {
-	if f.Radius <= 0 {
-		return fmt.Errorf("median: radius must be greater than zero")
-	}
-	return nil
+	return positiveRadius(f.Radius)
 }
 
 func (f Minimum) Validate() error {
-	if f.Radius <= 0 {
-		return fmt.Errorf("minimum: radius must be greater than zero")
-	}
-	return nil
+	return positiveRadius(f.Radius)
 }
 
 func (f Maximum) Validate() error {
-	if f.Radius <= 0 {
-		return fmt.Errorf("maximum: radius must be greater than zero")
-	}
-	return nil
+	return positiveRadius(f.Radius)
 }
 
 func (f Hue) Validate() error {
-	if f.Angle < -360 || f.Angle > 360 {
-		return fmt.Errorf("hue: angle must be between -360 and 360")
+	return inRange("angle", f.Angle, -360, 360)
+}
+
+func (f ColorBalance) Validate() error {
+	if err := inRange("red", f.Red, -1, 1); err != nil {
+		return err
+	}
+	if err := inRange("green", f.Green, -1, 1); err != nil {
+		return err
+	}
+	return inRange("blue", f.Blue, -1, 1)
+}
+
+func inRange(param string, v, lo, hi float32) error {
+	if v < lo || v > hi {
+		return paramError(param, "must be between %g and %g", lo, hi)
 	}
 	return nil
 }
 
-func (f ColorBalance) Validate() error {
-	if f.Red < -1 || f.Red > 1 || f.Green < -1 || f.Green > 1 || f.Blue < -1 || f.Blue > 1 {
-		return fmt.Errorf("colorbalance: each channel must be between -1 and 1")
+func positiveRadius(radius int) error {
+	if radius <= 0 {
+		return paramError("radius", "must be greater than zero")
 	}
 	return nil
 }
@@ -3,13 +3,24 @@ package types
 import (
 	"fmt"
 	"path"
+	"time"
 )
 
 // Image versioning model
 type ImageVersion struct {
-	UUID     string
-	Version  int
-	FilePath string
+	UUID      string
+	Version   int
+	FilePath  string
+	CreatedAt time.Time
+	// Generation is the history the version belongs to, see
+	// storage.ImageStore.ReplaceHistory
+	Generation uint64 `json:"-"`
+}
+
+// Reference to a stored image, pinned to a version or the current one
+type ImageRef struct {
+	UUID    string `json:"uuid"`
+	Version *int   `json:"version,omitempty"`
 }
 
 // Validate Images logic
@@ -0,0 +1,202 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+)
+
+// PDF page sizes in millimeters (portrait)
+var PDFPageSizes = map[string][2]float64{
+	"a4":     {210, 297},
+	"a5":     {148, 210},
+	"letter": {215.9, 279.4},
+	"legal":  {215.9, 355.6},
+}
+
+const (
+	PDFOrientationPortrait  = "portrait"
+	PDFOrientationLandscape = "landscape"
+	PDFOrientationAuto      = "auto"
+
+	PDFFitFit    = "fit"
+	PDFFitFill   = "fill"
+	PDFFitActual = "actual"
+
+	PDFImageJPEG    = "jpeg"    // DCT, lossy
+	PDFImagePNG     = "png"     // Flate, lossless
+	PDFImageGray    = "gray"    // 8-bit grayscale, Flate
+	PDFImageBilevel = "bilevel" // 1-bit black and white, for binarized scans
+)
+
+type PDFOptions struct {
+	PageSize    string  `json:"page_size"`   // a4, letter, legal, a5 or custom
+	PageWidth   float64 `json:"page_width"`  // mm, custom only
+	PageHeight  float64 `json:"page_height"` // mm, custom only
+	Orientation string  `json:"orientation"` // portrait, landscape or auto
+	Margin      float64 `json:"margin"`      // mm on every side
+	Fit         string  `json:"fit"`         // fit, fill or actual
+	DPI         float64 `json:"dpi"`         // used by the actual fit mode
+	SkipBlank   bool    `json:"skip_blank"`
+	ImageFormat string  `json:"image_format"` // jpeg, png, gray or bilevel
+	JPEGQuality int     `json:"jpeg_quality"` // 1 to 100
+	TargetDPI   float64 `json:"target_dpi"`   // downsample above this, 0 keeps the original
+
+	// Document metadata
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	Subject     string   `json:"subject"`
+	Keywords    string   `json:"keywords"`
+	Filename    string   `json:"filename"`     // download name, slurptools.pdf by default
+	Bookmarks   []string `json:"bookmarks"`    // outline title per input page, empty entries are skipped
+	PageNumbers bool     `json:"page_numbers"` // "n / total" footer on every page
+
+	// Encryption, applied when a password or a restriction is set
+	UserPassword  string `json:"user_password"`  // needed to open the document
+	OwnerPassword string `json:"owner_password"` // full access, random when empty
+	NoPrint       bool   `json:"no_print"`
+	NoCopy        bool   `json:"no_copy"`
+	NoModify      bool   `json:"no_modify"`
+}
+
+// PDF built from images already in the store
+type PDFRequest struct {
+	Images []ImageRef `json:"images"`
+	PDFOptions
+}
+
+// Pages imported from an uploaded PDF
+type ImportedPage struct {
+	Page int `json:"page"`
+	ImageVersion
+}
+
+type PDFImportResult struct {
+	Pages   []ImportedPage `json:"pages"`
+	Skipped []int          `json:"skipped"` // pages without a usable raster image
+}
+
+func DefaultPDFOptions() PDFOptions {
+	return PDFOptions{
+		PageSize:    "a4",
+		Orientation: PDFOrientationPortrait,
+		Margin:      10,
+		Fit:         PDFFitFit,
+		DPI:         300,
+		ImageFormat: PDFImageJPEG,
+		JPEGQuality: 85,
+	}
+}
+
+func (o PDFOptions) Validate() error {
+	// form values are parsed with strconv, which accepts NaN and Inf
+	numbers := []struct {
+		name  string
+		value float64
+	}{
+		{"page width", o.PageWidth}, {"page height", o.PageHeight}, {"margin", o.Margin},
+		{"dpi", o.DPI}, {"target dpi", o.TargetDPI},
+	}
+	for _, n := range numbers {
+		if math.IsNaN(n.value) || math.IsInf(n.value, 0) {
+			return fmt.Errorf("pdf: %s must be a finite number", n.name)
+		}
+	}
+
+	o.PageSize = strings.ToLower(o.PageSize)
+	if o.PageSize == "custom" {
+		if o.PageWidth <= 0 || o.PageHeight <= 0 {
+			return fmt.Errorf("pdf: custom page width and height must be greater than zero")
+		}
+	} else if _, ok := PDFPageSizes[o.PageSize]; !ok {
+		return fmt.Errorf("pdf: unknown page size %q", o.PageSize)
+	}
+
+	switch o.Orientation {
+	case PDFOrientationPortrait, PDFOrientationLandscape, PDFOrientationAuto:
+	default:
+		return fmt.Errorf("pdf: orientation must be portrait, landscape or auto")
+	}
+
+	switch o.Fit {
+	case PDFFitFit, PDFFitFill, PDFFitActual:
+	default:
+		return fmt.Errorf("pdf: fit must be fit, fill or actual")
+	}
+
+	w, h := o.PageDimensions(0, 0)
+	if o.Margin < 0 || 2*o.Margin >= w || 2*o.Margin >= h {
+		return fmt.Errorf("pdf: margin must not be negative and must leave room for the image")
+	}
+
+	if o.DPI <= 0 {
+		return fmt.Errorf("pdf: dpi must be greater than zero")
+	}
+
+	switch o.ImageFormat {
+	case PDFImageJPEG, PDFImagePNG, PDFImageGray, PDFImageBilevel:
+	default:
+		return fmt.Errorf("pdf: image format must be jpeg, png, gray or bilevel")
+	}
+
+	if o.JPEGQuality < 1 || o.JPEGQuality > 100 {
+		return fmt.Errorf("pdf: jpeg quality must be between 1 and 100")
+	}
+
+	if o.TargetDPI < 0 {
+		return fmt.Errorf("pdf: target dpi must be zero or greater")
+	}
+
+	if len(o.UserPassword) > 32 || len(o.OwnerPassword) > 32 {
+		return fmt.Errorf("pdf: passwords must be at most 32 bytes long")
+	}
+
+	if o.Filename != "" && o.DownloadName() == ".pdf" {
+		return fmt.Errorf("pdf: invalid filename")
+	}
+	return nil
+}
+
+// PageDimensions returns the page width and height in mm for an image of the
+// given pixel size, taking the orientation into account
+func (o PDFOptions) PageDimensions(imgWidth, imgHeight int) (float64, float64) {
+	var w, h float64
+	if size, ok := PDFPageSizes[strings.ToLower(o.PageSize)]; ok {
+		w, h = size[0], size[1]
+	} else {
+		w, h = o.PageWidth, o.PageHeight
+	}
+
+	landscape := o.Orientation == PDFOrientationLandscape ||
+		(o.Orientation == PDFOrientationAuto && imgWidth > imgHeight)
+	if landscape != (w > h) {
+		w, h = h, w
+	}
+	return w, h
+}
+
+// Encrypted reports whether the document needs the standard security handler
+func (o PDFOptions) Encrypted() bool {
+	return o.UserPassword != "" || o.OwnerPassword != "" || o.NoPrint || o.NoCopy || o.NoModify
+}
+
+// DownloadName returns a filename safe to put in a Content-Disposition header
+func (o PDFOptions) DownloadName() string {
+	if o.Filename == "" {
+		return "slurptools.pdf"
+	}
+
+	name := path.Base(strings.ReplaceAll(o.Filename, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(strings.TrimSuffix(name, path.Ext(name)))
+	if name == "." {
+		name = ""
+	}
+	return name + ".pdf"
+}
@@ -0,0 +1,27 @@
+package types
+
+import "encoding/json"
+
+// FilterPresets are named filter chains that can be used instead of listing
+// the filters
+var FilterPresets = map[string][]FilterRequest{
+	// cleanup applied after the scanner straightens a page
+	"scan": {
+		{Filter: "grayscale"},
+		{Filter: "scanify"},
+		{Filter: "median", Params: json.RawMessage(`{"radius": 1, "alpha": false}`)},
+		{Filter: "brightness", Params: json.RawMessage(`{"percentage": 15.0}`)},
+		{Filter: "contrast", Params: json.RawMessage(`{"percentage": 60.0}`)},
+		{Filter: "unsharpmask", Params: json.RawMessage(`{"sigma": 1.5, "amount": 1.0, "threshold": 0.5}`)},
+	},
+	"document": {
+		{Filter: "grayscale"},
+		{Filter: "contrast", Params: json.RawMessage(`{"percentage": 30.0}`)},
+		{Filter: "unsharpmask", Params: json.RawMessage(`{"sigma": 1.0, "amount": 0.8, "threshold": 0.5}`)},
+	},
+	"enhance": {
+		{Filter: "contrast", Params: json.RawMessage(`{"percentage": 10.0}`)},
+		{Filter: "saturation", Params: json.RawMessage(`{"percentage": 10.0}`)},
+		{Filter: "unsharpmask", Params: json.RawMessage(`{"sigma": 1.0, "amount": 0.5, "threshold": 0.5}`)},
+	},
+}
@@ -0,0 +1,56 @@
+package types
+
+import "fmt"
+
+// RecipeStep is one filter of an edit recipe, the id stays the same when the
+// step is edited or moved
+type RecipeStep struct {
+	ID string `json:"id"`
+	FilterRequest
+}
+
+// Recipe describes how a version is rendered: the steps applied in order on
+// top of the base version's raster. Versions that were not produced by
+// filters, such as uploads or scans, are their own base.
+type Recipe struct {
+	Base  int          `json:"base"`
+	Steps []RecipeStep `json:"steps"`
+	// Origin identifies the base raster, unlike Base it is never reused
+	Origin string `json:"-"`
+}
+
+// Clone copies the steps so the recipe can be changed without touching the
+// one stored with another version
+func (r Recipe) Clone() Recipe {
+	steps := make([]RecipeStep, len(r.Steps))
+	copy(steps, r.Steps)
+	return Recipe{Base: r.Base, Steps: steps, Origin: r.Origin}
+}
+
+// StepIndex returns the position of the step with id
+func (r Recipe) StepIndex(id string) (int, error) {
+	for i, s := range r.Steps {
+		if s.ID == id {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("recipe: step %s not found", id)
+}
+
+// Filters returns the filter requests of the steps, in order
+func (r Recipe) Filters() []FilterRequest {
+	filters := make([]FilterRequest, len(r.Steps))
+	for i, s := range r.Steps {
+		filters[i] = s.FilterRequest
+	}
+	return filters
+}
+
+type MoveStepRequest struct {
+	Position int `json:"position"`
+}
+
+type RecipeResult struct {
+	Version ImageVersion `json:"version"`
+	Recipe  Recipe       `json:"recipe"`
+}
@@ -0,0 +1,157 @@
+package types
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/vector"
+)
+
+// MaxRedactRegions bounds how many regions one redaction may cover
+const MaxRedactRegions = 500
+
+// RedactRegion is either a rectangle or, when Points is set, a polygon
+type RedactRegion struct {
+	X      int          `json:"x"`
+	Y      int          `json:"y"`
+	Width  int          `json:"width"`
+	Height int          `json:"height"`
+	Points [][2]float64 `json:"points,omitempty"`
+}
+
+type RedactRequest struct {
+	Regions []RedactRegion `json:"regions"`
+	Color   string         `json:"color"` // opaque #rgb or #rrggbb, black by default
+}
+
+type RedactResult struct {
+	Version ImageVersion `json:"version"`
+	// Discarded counts the older versions that were removed with the
+	// unredacted pixels they held
+	Discarded int `json:"discarded"`
+}
+
+func (r RedactRequest) Validate() error {
+	if len(r.Regions) == 0 {
+		return fmt.Errorf("redact: provide at least one region")
+	}
+	if len(r.Regions) > MaxRedactRegions {
+		return fmt.Errorf("redact: at most %d regions per request", MaxRedactRegions)
+	}
+	if r.Color != "" {
+		c, err := parseHexColor(r.Color)
+		if err != nil {
+			return fmt.Errorf("redact: color must be #rgb or #rrggbb")
+		}
+		if c.A != 0xff {
+			return fmt.Errorf("redact: color must be opaque")
+		}
+	}
+
+	for i, region := range r.Regions {
+		switch {
+		case region.Points != nil && len(region.Points) < 3:
+			return fmt.Errorf("redact: region %d needs at least 3 points", i)
+		case region.Points == nil && (region.Width <= 0 || region.Height <= 0):
+			return fmt.Errorf("redact: region %d needs a positive width and height", i)
+		case region.Points == nil && !validRect(region):
+			return fmt.Errorf("redact: region %d must lie between -%d and %d", i, maxCoordinate, maxCoordinate)
+		}
+		for _, p := range region.Points {
+			if !validPoint(p) {
+				return fmt.Errorf("redact: region %d points must be finite and between -%d and %d", i, maxCoordinate, maxCoordinate)
+			}
+		}
+	}
+	return nil
+}
+
+func validRect(region RedactRegion) bool {
+	// the size is bounded first so the sums cannot overflow
+	return region.Width <= 2*maxCoordinate && region.Height <= 2*maxCoordinate &&
+		validPoint([2]float64{float64(region.X), float64(region.Y)}) &&
+		validPoint([2]float64{float64(region.X + region.Width), float64(region.Y + region.Height)})
+}
+
+func (r RedactRequest) fill() color.Color {
+	if r.Color == "" {
+		return color.Black
+	}
+	c, _ := parseHexColor(r.Color)
+	return c
+}
+
+// Redact returns a copy of src with every region painted over in a solid
+// color. Polygon edges are not antialiased: any pixel a region even partly
+// covers is replaced entirely, so nothing of its original value is blended
+// into the result.
+func Redact(src image.Image, req RedactRequest) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+
+	fill := image.NewUniform(req.fill())
+	for _, region := range req.Regions {
+		if region.Points == nil {
+			rect := image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height).Add(bounds.Min)
+			draw.Draw(dst, rect.Intersect(bounds), fill, image.Point{}, draw.Src)
+			continue
+		}
+
+		mask, area := polygonMask(region.Points, bounds)
+		if mask == nil {
+			continue
+		}
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				if mask.AlphaAt(x, y).A > 0 {
+					dst.Set(x, y, fill.C)
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// polygonMask rasterizes poly, given relative to bounds, over the part of
+// bounds it covers
+func polygonMask(poly [][2]float64, bounds image.Rectangle) (*image.Alpha, image.Rectangle) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range poly {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	area := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1).
+		Add(bounds.Min).Intersect(bounds)
+	if area.Empty() {
+		return nil, area
+	}
+
+	if signedArea(poly) < 0 {
+		poly = reversed(poly)
+	}
+	poly = clipPolygon(poly, area.Sub(bounds.Min))
+	if len(poly) == 0 {
+		return nil, area
+	}
+	var z vector.Rasterizer
+	z.Reset(area.Dx(), area.Dy())
+	offX, offY := float64(area.Min.X-bounds.Min.X), float64(area.Min.Y-bounds.Min.Y)
+	for i, p := range poly {
+		x, y := float32(p[0]-offX), float32(p[1]-offY)
+		if i == 0 {
+			z.MoveTo(x, y)
+		} else {
+			z.LineTo(x, y)
+		}
+	}
+	z.ClosePath()
+
+	mask := image.NewAlpha(area)
+	z.Draw(mask, area, image.Opaque, image.Point{})
+	return mask, area
+}
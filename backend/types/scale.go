@@ -0,0 +1,104 @@
+package types
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// Scaler is implemented by filters with parameters measured in pixels, which
+// have to follow the image when it is rendered at another size
+type Scaler interface {
+	Scale(s float64) Filter
+}
+
+func (f Resize) Scale(s float64) Filter {
+	f.Width, f.Height = scalePixels(f.Width, s), scalePixels(f.Height, s)
+	return f
+}
+
+func (f Crop) Scale(s float64) Filter {
+	f.X, f.Y = int(math.Round(float64(f.X)*s)), int(math.Round(float64(f.Y)*s))
+	f.Width, f.Height = scalePixels(f.Width, s), scalePixels(f.Height, s)
+	return f
+}
+
+func (f GaussianBlur) Scale(s float64) Filter {
+	f.Sigma = scaleSigma(f.Sigma, s)
+	return f
+}
+
+func (f UnsharpMask) Scale(s float64) Filter {
+	f.Sigma = scaleSigma(f.Sigma, s)
+	return f
+}
+
+func (f Pixelate) Scale(s float64) Filter {
+	f.Size = scalePixels(f.Size, s)
+	return f
+}
+
+func (f Mean) Scale(s float64) Filter {
+	f.Radius = scalePixels(f.Radius, s)
+	return f
+}
+
+func (f Median) Scale(s float64) Filter {
+	f.Radius = scalePixels(f.Radius, s)
+	return f
+}
+
+func (f Minimum) Scale(s float64) Filter {
+	f.Radius = scalePixels(f.Radius, s)
+	return f
+}
+
+func (f Maximum) Scale(s float64) Filter {
+	f.Radius = scalePixels(f.Radius, s)
+	return f
+}
+
+func (f Annotate) Scale(s float64) Filter {
+	points := make([][2]float64, len(f.Points))
+	for i, p := range f.Points {
+		points[i] = [2]float64{p[0] * s, p[1] * s}
+	}
+	f.Points = points
+	f.Width = f.strokeWidth() * s
+	if f.Shape == "text" {
+		f.Size = f.fontSize() * s
+	}
+	return f
+}
+
+// ScaleFilters returns the chain with pixel parameters multiplied by s, so a
+// chain meant for the full image looks the same on a downscaled copy
+func ScaleFilters(frs []FilterRequest, s float64) ([]FilterRequest, error) {
+	filters, err := CreateFilters(frs)
+	if err != nil {
+		return nil, err
+	}
+
+	scaled := make([]FilterRequest, len(frs))
+	for i, f := range filters {
+		scaled[i] = frs[i]
+		scaler, ok := f.(Scaler)
+		if !ok {
+			continue
+		}
+		params, err := json.Marshal(scaler.Scale(s))
+		if err != nil {
+			return nil, err
+		}
+		scaled[i].Params = params
+	}
+	return scaled, nil
+}
+
+// scalePixels keeps sizes at one pixel or more so the filters stay valid
+func scalePixels(v int, s float64) int {
+	return max(int(math.Round(float64(v)*s)), 1)
+}
+
+func scaleSigma(v float32, s float64) float32 {
+	return max(v*float32(s), 0.1)
+}
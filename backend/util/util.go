@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"math/rand"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 )
 
 func WriteJSON(w http.ResponseWriter, statusCode int, payload any) error {
@@ -21,3 +24,21 @@ func RandSeq(n int) string {
 	}
 	return string(b)
 }
+
+// EnvInt reads an integer environment variable, falling back to def when unset or invalid
+func EnvInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// EnvDuration reads a duration such as "30s" or "1h" from the environment
+func EnvDuration(name string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -17,14 +17,28 @@ FRONTEND_PID=$!
 /bin/backend-server >/dev/null 2>&1 &
 BACKEND_PID=$!
 
-for pid in $API_PID $FRONTEND_PID $BACKEND_PID; do
-    if ! kill -0 $pid 2>/dev/null; then
-        echo -e "\e[1;31mError: there was a problem with a process: (PID $pid).\e[0m"
-        cleanup
-    fi
-done
-
-sleep 3
+# Espera a que el backend responda /readyz (almacenamiento, disco y scanner)
+wait_ready() {
+    for _ in $(seq 1 ${READY_TIMEOUT:-60}); do
+        for pid in $API_PID $FRONTEND_PID $BACKEND_PID; do
+            if ! kill -0 $pid 2>/dev/null; then
+                echo -e "\e[1;31mError: there was a problem with a process: (PID $pid).\e[0m"
+                return 1
+            fi
+        done
+        if node -e "fetch('http://localhost:3000/readyz').then(r => process.exit(r.ok ? 0 : 1)).catch(() => process.exit(1))"; then
+            return 0
+        fi
+        sleep 1
+    done
+    echo -e "\e[1;31mError: the services did not become ready in time.\e[0m"
+    node -e "fetch('http://localhost:3000/readyz').then(r => r.text()).then(console.log).catch(() => {})"
+    return 1
+}
+
+if ! wait_ready; then
+    cleanup
+fi
 
 clear
 
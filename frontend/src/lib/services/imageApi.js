@@ -58,9 +58,14 @@ export async function applyResizeFilter(uuid, width, height) {
 	}
 }
 
-export function getRenderImage(uuid) {
+// The version query changes with every edit, so the browser refetches after
+// one, and otherwise revalidates its cached copy against the server's ETag,
+// which identifies the version the same way.
+export function getRenderImage(uuid, version) {
 	if (!uuid) throw new Error('UUID is required to build image URL');
-	return `${API_BASE_URL}/image/${uuid}/download?t=${Date.now()}`;
+	const url = `${API_BASE_URL}/image/${uuid}/download`;
+	if (!version) return url;
+	return `${url}?v=${version.Version}-${Date.parse(version.CreatedAt) || 0}`;
 }
 
 export async function redoChanges(uuid) {
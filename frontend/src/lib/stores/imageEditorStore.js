@@ -100,7 +100,7 @@ function createImageEditorStore() {
 	}
 
 	async function refreshImage(resetApplying = true) {
-		const currentUUID = get({ subscribe }).imageUUID;
+		const { imageUUID: currentUUID, imageInfo } = get({ subscribe });
 		if (!currentUUID || !browser) {
 			_resetState();
 			return;
@@ -110,7 +110,7 @@ function createImageEditorStore() {
 		if (resetApplying) _setApplying(false);
 		_cleanupBlobUrl();
 		await new Promise((resolve) => setTimeout(resolve, 0));
-		const newImageUrl = imageApi.getRenderImage(currentUUID) + '?t=' + Date.now();
+		const newImageUrl = imageApi.getRenderImage(currentUUID, imageInfo);
 		update((state) => ({ ...state, imageUrl: newImageUrl, originalDimensions: null }));
 	}
 
@@ -25,6 +25,10 @@ app.add_middleware(
     allow_methods=("GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"),
 )
 
+@app.get("/healthz")
+async def healthz():
+    return {"status": "ok"}
+
 @app.post("/scanner")
 async def scanner_endpoint(file: UploadFile = File(...), points: str = Form(...)):
     image_bytes = await file.read()